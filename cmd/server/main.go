@@ -1,48 +1,270 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
 	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
 	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/db"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/health"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/metrics"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/sqlite"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/tracing"
 	transportHttp "github.com/JonathanBaggott/go-rest-api-course-v2/internal/transport/http"
+	log "github.com/sirupsen/logrus"
 )
 
-// Run - responsible for the instantiation and startup of our Go application
-func Run() error {
-	fmt.Println("starting up our application")
+// Run - responsible for the instantiation and startup of our Go application.
+// It serves until ctx is cancelled, then drains in-flight requests, stops the background
+// workers and closes the store before returning.
+func Run(ctx context.Context, cfg config.Config) error {
+	log.Info("starting up our application")
 
-	// Connect to the database
-	db, err := db.NewDatabase()
+	// Send traces to the configured exporter, flushing whatever is buffered on the way out
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
 	if err != nil {
-		fmt.Println("Failed to connect to the database!")
 		return err
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.WithError(err).Error("failed to flush traces")
+		}
+	}()
 
-	// Migrate the database schema
-	if err := db.MigrateDB(); err != nil {
-		fmt.Println("failed to migrate database")
+	// Load the keys used to verify bearer tokens
+	verifier, err := auth.NewVerifier(ctx, cfg.Auth)
+	if err != nil {
+		return err
 	}
 
-	// Create a new comment service instance and inject the database
-	cmtService := comment.NewService(db)
+	// The development token endpoint signs tokens for anyone who asks, so it is off unless configured
+	var tokens transportHttp.TokenIssuer
+	if cfg.Auth.DevTokenEndpoint {
+		signer, err := auth.NewSigner(cfg.Auth)
+		if err != nil {
+			return err
+		}
+		log.Warn("the development token endpoint is enabled; never do this in production")
+		tokens = signer
+	}
 
-	// Create an HTTP handler and inject the comment service
-	httpHandler := transportHttp.NewHandler(cmtService)
+	// Every metric is registered here and served on /metrics
+	appMetrics := metrics.New()
+	// Dependencies register their checks here; they are reported on /healthz and /readyz
+	checks := health.New(cfg.Server.HealthCheckTimeout)
 
-	// Start the HTTP server and handle requests
-	if err := httpHandler.Serve(); err != nil {
+	// Pick where comments are stored
+	store, closeStore, err := newStore(ctx, cfg.Database, appMetrics, checks)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := closeStore(); err != nil {
+			log.WithError(err).Error("failed to close the store")
+		}
+	}()
+
+	// Background workers get their own context so they keep going while requests drain,
+	// and are stopped before the store they use is closed
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	startWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workersCtx)
+		}()
+	}
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+		log.Info("background workers stopped")
+	}()
+
+	// Keep any JWKS up to date
+	startWorker(verifier.Run)
+
+	// Create a new comment service instance and inject the store
+	cmtService := comment.NewService(store, cfg.Comments)
+	cmtService.Metrics = appMetrics
+	// API keys live in the same store as the comments
+	apiKeys := auth.NewAPIKeys(store)
+
+	// Periodically purge soft deleted comments once they are past their retention
+	startWorker(func(ctx context.Context) {
+		cmtService.RunPurgeJob(ctx, cfg.Comments.PurgeInterval, cfg.Comments.PurgeRetention)
+	})
+
+	// Create an HTTP handler and inject the comment service
+	httpHandler := transportHttp.NewHandler(cmtService, cfg.Server, verifier, apiKeys, tokens, appMetrics, checks)
+
+	// Start the HTTP server and handle requests until we're told to stop
+	return httpHandler.Serve(ctx)
+}
 
-	fmt.Println("successfully connected and pinged database")
-	return nil
+// pinger is implemented by stores that can check their connection to the database
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// store is everything the application keeps in its database
+type store interface {
+	comment.Store
+	auth.KeyStore
+}
+
+// newStore creates the comment store for the configured backend, timing and tracing every call made to it.
+// Postgres is the default; "sqlite" stores comments in a local file, and "memory" keeps
+// them in process memory, which is handy for local development.
+// Backends that can be pinged are checked for readiness. The returned function closes the store.
+func newStore(ctx context.Context, cfg config.Database, m *metrics.Metrics, checks *health.Health) (store, func() error, error) {
+	s, err := openStore(ctx, cfg, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p, ok := s.(pinger); ok {
+		checks.AddReadinessCheck(health.CheckFunc(cfg.Backend, p.Ping))
+	}
+
+	closeStore := func() error { return nil }
+	if c, ok := s.(io.Closer); ok {
+		closeStore = func() error {
+			log.Info("closing the store")
+			return c.Close()
+		}
+	}
+	return m.InstrumentStore(tracing.InstrumentStore(s, cfg.Backend), cfg.Backend), closeStore, nil
+}
+
+// openStore connects to the configured backend and migrates its schema, exposing its
+// connection pool statistics if it has one. Postgres may still be starting up, so connecting
+// to it is retried for a while.
+func openStore(ctx context.Context, cfg config.Database, m *metrics.Metrics) (store, error) {
+	switch cfg.Backend {
+	case config.BackendPostgres:
+		// Connect to the database
+		db, err := db.Connect(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		// Migrate the database schema, closing the connection if the store can't be used
+		if err := migrateOnStartup(cfg, db.MigrateDB); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if err := m.RegisterDB(db.Client.DB, cfg.Name); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	case config.BackendSQLite:
+		db, err := sqlite.NewDatabase(cfg.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Migrate the database schema, closing the database if the store can't be used
+		if err := migrateOnStartup(cfg, db.MigrateDB); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if err := m.RegisterDB(db.Client.DB, cfg.SQLitePath); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	case config.BackendMemory:
+		log.Warn("storing comments in memory; they will be lost on shutdown")
+		return memory.NewStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", cfg.Backend)
+	}
+}
+
+// migrateOnStartup migrates a store's schema on startup, unless that has been switched off.
+// The server refuses to run against a schema it doesn't expect, unless it has been told
+// to ignore migration errors.
+func migrateOnStartup(cfg config.Database, migrateDB func() error) error {
+	if cfg.SkipMigrations {
+		log.Info("skipping database migrations")
+		return nil
+	}
+	err := migrateDB()
+	if err == nil {
+		return nil
+	}
+	if cfg.IgnoreMigrationErrors {
+		log.WithError(err).Warn("failed to migrate the database; starting anyway as migration errors are ignored")
+		return nil
+	}
+	return fmt.Errorf("could not migrate the database: %w", err)
 }
 
 func main() {
-	fmt.Println("Go REST API Course")
-	err := Run()
+	// Subcommands do a single job with the server's configuration instead of starting it:
+	// "token" mints a token, printing nothing but the token to stdout, and "migrate" manages the schema
+	subcommands := map[string]func(args []string) error{
+		"token":   runToken,
+		"migrate": runMigrate,
+	}
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					return
+				}
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	// Load the configuration from the defaults, config file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
 		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := logging.Setup(cfg.Logging); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	log.Info("Go REST API Course")
+
+	// SIGINT (Ctrl+C) and SIGTERM (docker stop, Kubernetes) shut the server down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Restore the default behaviour so that a second signal kills the server straight away
+		stop()
+	}()
+
+	if err := Run(ctx, cfg); err != nil {
+		log.WithError(err).Error("the server stopped")
+		os.Exit(1)
 	}
 }
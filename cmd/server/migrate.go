@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/db"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/sqlite"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+const migrateUsage = `usage: app migrate [flags] <command>
+
+commands:
+  up         apply every pending migration
+  down [N]   roll back the last N migrations, 1 by default
+  goto V     migrate up or down to version V
+  force V    set the version to V without running anything, once a failed migration
+             has been fixed by hand; -1 means no migration has been applied
+  status     show the current version and which migrations are pending
+`
+
+// runMigrate implements the "migrate" subcommand, which manages the schema of the configured
+// database using the migrations embedded in the binary, e.g.
+//
+//	app migrate status
+//	app migrate down 2
+//
+// The usual configuration file, environment variables and flags choose the database.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlagSet(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := logging.Setup(cfg.Logging); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("a command is required")
+	}
+
+	m, source, err := openMigrator(context.Background(), cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	m.Log = migrateLogger{}
+
+	command, params := fs.Arg(0), fs.Args()[1:]
+	switch command {
+	case "up":
+		if err := noParams(command, params); err != nil {
+			return err
+		}
+		err = m.Up()
+	case "down":
+		steps := 1
+		if len(params) > 0 {
+			if steps, err = intParam(command, params); err != nil {
+				return err
+			}
+			if steps < 1 {
+				return errors.New("down needs a positive number of migrations")
+			}
+		}
+		err = m.Steps(-steps)
+	case "goto":
+		version, paramErr := intParam(command, params)
+		if paramErr != nil {
+			return paramErr
+		}
+		if version < 0 {
+			return errors.New("goto needs a version that isn't negative")
+		}
+		err = m.Migrate(uint(version))
+	case "force":
+		version, paramErr := intParam(command, params)
+		if paramErr != nil {
+			return paramErr
+		}
+		err = m.Force(version)
+	case "status":
+		if err := noParams(command, params); err != nil {
+			return err
+		}
+		return printStatus(m, source)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		err = nil
+		fmt.Fprintln(os.Stderr, "no change")
+	}
+	if err != nil {
+		return err
+	}
+	return printVersion(m)
+}
+
+// openMigrator connects to the configured database and returns a migrator for it along with
+// the migrations it applies. Closing the migrator closes the connection.
+func openMigrator(ctx context.Context, cfg config.Database) (*migrate.Migrate, fs.FS, error) {
+	switch cfg.Backend {
+	case config.BackendPostgres:
+		database, err := db.Connect(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := database.Migrator()
+		if err != nil {
+			// Without a migrator to close it, the connection has to be closed here
+			database.Close()
+			return nil, nil, err
+		}
+		return m, migrations.Postgres(), nil
+	case config.BackendSQLite:
+		database, err := sqlite.NewDatabase(cfg.SQLitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := database.Migrator()
+		if err != nil {
+			database.Close()
+			return nil, nil, err
+		}
+		return m, migrations.SQLite(), nil
+	case config.BackendMemory:
+		return nil, nil, errors.New("the memory backend has no schema to migrate")
+	default:
+		return nil, nil, fmt.Errorf("unknown database backend %q", cfg.Backend)
+	}
+}
+
+// printVersion prints the version the schema is at
+func printVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		fmt.Printf("version %d (dirty: the last migration failed; fix it and run force)\n", version)
+		return nil
+	}
+	fmt.Printf("version %d\n", version)
+	return nil
+}
+
+// printStatus prints the version the schema is at followed by every migration and whether it has been applied
+func printStatus(m *migrate.Migrate, source fs.FS) error {
+	if err := printVersion(m); err != nil {
+		return err
+	}
+	current, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	// Without a version nothing has been applied yet
+	anyApplied := err == nil
+
+	list, err := migrations.List(source)
+	if err != nil {
+		return err
+	}
+	for _, mig := range list {
+		state := "pending"
+		if anyApplied && mig.Version <= current {
+			state = "applied"
+		}
+		fmt.Printf("  %-8s %04d %s\n", state, mig.Version, mig.Name)
+	}
+	return nil
+}
+
+func noParams(command string, params []string) error {
+	if len(params) > 0 {
+		return fmt.Errorf("%s takes no arguments", command)
+	}
+	return nil
+}
+
+// intParam parses the single integer argument of a command
+func intParam(command string, params []string) (int, error) {
+	if len(params) != 1 {
+		return 0, fmt.Errorf("%s takes a single number", command)
+	}
+	n, err := strconv.Atoi(params[0])
+	if err != nil {
+		return 0, fmt.Errorf("%s takes a single number, got %q", command, params[0])
+	}
+	return n, nil
+}
+
+// migrateLogger reports the progress of golang-migrate through the structured logger
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...interface{}) {
+	log.Info(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (migrateLogger) Verbose() bool {
+	return false
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+)
+
+const (
+	// apiKeyPrefix starts every API key so leaked keys are easy to recognise
+	apiKeyPrefix = "cmt_"
+	// apiKeyDisplayLength is how much of a key is kept in clear to tell keys apart
+	apiKeyDisplayLength = 12
+	// touchInterval limits how often a key's last used time is written
+	touchInterval = time.Minute
+)
+
+var (
+	// ErrInvalidAPIKey is wrapped by every error returned for a key that can't be used
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyNotFound is returned when a key doesn't exist or has already been revoked
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey - the stored details of an API key. The key itself is only ever returned
+// when it is created; afterwards only its hash is kept.
+type APIKey struct {
+	ID     string
+	Name   string
+	Prefix string
+	Scopes []string
+	// CreatedBy is the subject that created the key
+	CreatedBy  string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// KeyStore - the methods API keys need from a store
+type KeyStore interface {
+	// CreateAPIKey stores a key along with the hash of its secret, generating its ID and CreatedAt
+	CreateAPIKey(ctx context.Context, key APIKey, hash string) (APIKey, error)
+	// GetAPIKeyByHash returns the key whose secret hashes to hash, revoked or not
+	GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// RevokeAPIKey marks a key as revoked, returning ErrAPIKeyNotFound if there's no such unrevoked key
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// APIKeys - creates, lists and revokes API keys and authenticates requests carrying them
+type APIKeys struct {
+	Store KeyStore
+	now   func() time.Time
+}
+
+// NewAPIKeys returns the API key service for a store
+func NewAPIKeys(store KeyStore) *APIKeys {
+	return &APIKeys{Store: store, now: time.Now}
+}
+
+// CreateKey generates a new API key and returns its details along with the key itself,
+// which can't be recovered later.
+func (a *APIKeys) CreateKey(ctx context.Context, key APIKey) (APIKey, string, error) {
+	secret, err := generateAPIKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key.Prefix = secret[:apiKeyDisplayLength]
+	created, err := a.Store.CreateAPIKey(ctx, key, hashAPIKey(secret))
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return created, secret, nil
+}
+
+// ListKeys returns every API key, including revoked and expired ones
+func (a *APIKeys) ListKeys(ctx context.Context) ([]APIKey, error) {
+	return a.Store.ListAPIKeys(ctx)
+}
+
+// RevokeKey stops a key from being used any more
+func (a *APIKeys) RevokeKey(ctx context.Context, id string) error {
+	return a.Store.RevokeAPIKey(ctx, id, a.now())
+}
+
+// Authenticate checks an API key and returns claims carrying its scopes.
+// The subject is "apikey:" followed by the key's ID.
+func (a *APIKeys) Authenticate(ctx context.Context, secret string) (Claims, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return Claims{}, ErrInvalidAPIKey
+	}
+
+	key, err := a.Store.GetAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return Claims{}, ErrInvalidAPIKey
+		}
+		return Claims{}, err
+	}
+
+	now := a.now()
+	if key.RevokedAt != nil {
+		return Claims{}, fmt.Errorf("%w: api key has been revoked", ErrInvalidAPIKey)
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return Claims{}, fmt.Errorf("%w: api key has expired", ErrInvalidAPIKey)
+	}
+
+	// Recording every single use would turn each read into a write
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchInterval {
+		if err := a.Store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("api_key_id", key.ID).Warn("failed to record api key use")
+		}
+	}
+
+	claims := Claims{
+		Subject: "apikey:" + key.ID,
+		Scopes:  key.Scopes,
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = *key.ExpiresAt
+	}
+	return claims, nil
+}
+
+// generateAPIKey returns a new random API key
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate api key: %w", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the hash stored for a key. Keys are long and random, so a fast
+// unsalted hash is enough and lets keys be looked up by their hash.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	keys := auth.NewAPIKeys(store)
+
+	key, secret, err := keys.CreateKey(ctx, auth.APIKey{Name: "ci", Scopes: []string{auth.ScopeCommentsWrite}, CreatedBy: "admin"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, key.Prefix))
+	assert.True(t, strings.HasPrefix(secret, "cmt_"))
+
+	t.Run("authenticates with the key's scopes", func(t *testing.T) {
+		claims, err := keys.Authenticate(ctx, secret)
+		require.NoError(t, err)
+		assert.Equal(t, "apikey:"+key.ID, claims.Subject)
+		assert.True(t, claims.HasScope(auth.ScopeCommentsWrite))
+		assert.False(t, claims.HasScope(auth.ScopeCommentsModerate))
+
+		listed, err := keys.ListKeys(ctx)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		assert.NotNil(t, listed[0].LastUsedAt)
+	})
+
+	t.Run("rejects unknown keys", func(t *testing.T) {
+		for _, secret := range []string{"", "nope", "cmt_unknown", secret + "x"} {
+			_, err := keys.Authenticate(ctx, secret)
+			assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+		}
+	})
+
+	t.Run("rejects expired keys", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		_, secret, err := keys.CreateKey(ctx, auth.APIKey{Name: "old", ExpiresAt: &expired})
+		require.NoError(t, err)
+
+		_, err = keys.Authenticate(ctx, secret)
+		assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+	})
+
+	t.Run("rejects revoked keys", func(t *testing.T) {
+		require.NoError(t, keys.RevokeKey(ctx, key.ID))
+		_, err := keys.Authenticate(ctx, secret)
+		assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+
+		assert.ErrorIs(t, keys.RevokeKey(ctx, key.ID), auth.ErrAPIKeyNotFound)
+	})
+}
@@ -0,0 +1,109 @@
+// Package auth verifies the bearer tokens clients authenticate with
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes that routes may require
+const (
+	// ScopeCommentsWrite allows posting, editing and deleting comments
+	ScopeCommentsWrite = "comments:write"
+	// ScopeCommentsModerate allows restoring deleted comments and managing anyone's comments
+	ScopeCommentsModerate = "comments:moderate"
+	// ScopeAPIKeysAdmin allows creating, listing and revoking API keys
+	ScopeAPIKeysAdmin = "apikeys:admin"
+)
+
+// Scopes lists every scope the API knows about
+var Scopes = []string{ScopeCommentsWrite, ScopeCommentsModerate, ScopeAPIKeysAdmin}
+
+// roleScopes lists the scopes granted by each role a token may carry in its "roles" claim
+var roleScopes = map[string][]string{
+	"commenter": {ScopeCommentsWrite},
+	"moderator": {ScopeCommentsWrite, ScopeCommentsModerate},
+	"admin":     Scopes,
+}
+
+// ErrInvalidToken is wrapped by every error returned for a token that fails verification
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the verified claims of a bearer token
+type Claims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	Scopes    []string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+// HasScope reports whether the token was granted the given scope, either directly
+// or through one of its roles
+func (c Claims) HasScope(scope string) bool {
+	if contains(c.Scopes, scope) {
+		return true
+	}
+	for _, role := range c.Roles {
+		if contains(roleScopes[role], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingScopes returns the scopes in required that the token wasn't granted
+func (c Claims) MissingScopes(required ...string) []string {
+	var missing []string
+	for _, scope := range required {
+		if !c.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the verified claims of the caller
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims stored by NewContext, if any
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+// parseScopes reads the scopes granted by a token. The standard "scope" claim is a space
+// separated string; some issuers use "scp" instead, either as a string or as a list.
+func parseScopes(raw map[string]interface{}) []string {
+	return stringsClaim(raw, "scope", "scp")
+}
+
+// parseRoles reads the roles listed in the "roles" claim
+func parseRoles(raw map[string]interface{}) []string {
+	return stringsClaim(raw, "roles")
+}
+
+// stringsClaim collects the values of claims that are either space separated strings or lists of strings
+func stringsClaim(raw map[string]interface{}, names ...string) []string {
+	var values []string
+	for _, name := range names {
+		switch v := raw[name].(type) {
+		case string:
+			values = append(values, strings.Fields(v)...)
+		case []interface{}:
+			for _, s := range v {
+				if s, ok := s.(string); ok && s != "" {
+					values = append(values, s)
+				}
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,118 @@
+// Package authtest holds the behaviour every auth.KeyStore implementation must share.
+package authtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunKeyStoreTests runs the conformance suite against an auth.KeyStore.
+// newStore is called once per sub-test; stores may be shared between calls, as every
+// sub-test only asserts on the keys it created.
+func RunKeyStoreTests(t *testing.T, newStore func(t *testing.T) auth.KeyStore) {
+	ctx := context.Background()
+
+	// uniqueHash keeps keys from earlier runs against a persistent store from clashing
+	uniqueHash := func() string {
+		return "hash-" + uuid.NewV4().String()
+	}
+
+	t.Run("create and fetch by hash", func(t *testing.T) {
+		store := newStore(t)
+		expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		hash := uniqueHash()
+
+		created, err := store.CreateAPIKey(ctx, auth.APIKey{
+			ID:        "client-chosen-id",
+			Name:      "integration",
+			Prefix:    "cmt_abcdefgh",
+			Scopes:    []string{auth.ScopeCommentsWrite, auth.ScopeCommentsModerate},
+			CreatedBy: "admin",
+			ExpiresAt: &expires,
+		}, hash)
+		require.NoError(t, err)
+		// The store always generates the ID and creation time itself.
+		assert.NotEqual(t, "client-chosen-id", created.ID)
+		assert.WithinDuration(t, time.Now(), created.CreatedAt, time.Minute)
+
+		got, err := store.GetAPIKeyByHash(ctx, hash)
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, got.ID)
+		assert.Equal(t, "integration", got.Name)
+		assert.Equal(t, "cmt_abcdefgh", got.Prefix)
+		assert.Equal(t, []string{auth.ScopeCommentsWrite, auth.ScopeCommentsModerate}, got.Scopes)
+		assert.Equal(t, "admin", got.CreatedBy)
+		require.NotNil(t, got.ExpiresAt)
+		assert.True(t, expires.Equal(*got.ExpiresAt))
+		assert.Nil(t, got.LastUsedAt)
+		assert.Nil(t, got.RevokedAt)
+
+		_, err = store.GetAPIKeyByHash(ctx, uniqueHash())
+		assert.ErrorIs(t, err, auth.ErrAPIKeyNotFound)
+	})
+
+	t.Run("keys without expiry or scopes", func(t *testing.T) {
+		store := newStore(t)
+		hash := uniqueHash()
+
+		_, err := store.CreateAPIKey(ctx, auth.APIKey{Name: "read only", Prefix: "cmt_12345678"}, hash)
+		require.NoError(t, err)
+
+		got, err := store.GetAPIKeyByHash(ctx, hash)
+		require.NoError(t, err)
+		assert.Empty(t, got.Scopes)
+		assert.Nil(t, got.ExpiresAt)
+	})
+
+	t.Run("list keys", func(t *testing.T) {
+		store := newStore(t)
+
+		first, err := store.CreateAPIKey(ctx, auth.APIKey{Name: "first", Prefix: "cmt_first"}, uniqueHash())
+		require.NoError(t, err)
+		second, err := store.CreateAPIKey(ctx, auth.APIKey{Name: "second", Prefix: "cmt_second"}, uniqueHash())
+		require.NoError(t, err)
+
+		keys, err := store.ListAPIKeys(ctx)
+		require.NoError(t, err)
+		positions := map[string]int{}
+		for i, k := range keys {
+			positions[k.ID] = i
+		}
+		require.Contains(t, positions, first.ID)
+		require.Contains(t, positions, second.ID)
+		assert.Less(t, positions[first.ID], positions[second.ID])
+	})
+
+	t.Run("revoke and touch", func(t *testing.T) {
+		store := newStore(t)
+		hash := uniqueHash()
+		key, err := store.CreateAPIKey(ctx, auth.APIKey{Name: "revoked", Prefix: "cmt_revoked"}, hash)
+		require.NoError(t, err)
+
+		usedAt := time.Now().UTC().Truncate(time.Second)
+		require.NoError(t, store.TouchAPIKey(ctx, key.ID, usedAt))
+		require.NoError(t, store.RevokeAPIKey(ctx, key.ID, usedAt.Add(time.Second)))
+
+		got, err := store.GetAPIKeyByHash(ctx, hash)
+		require.NoError(t, err)
+		require.NotNil(t, got.LastUsedAt)
+		assert.True(t, usedAt.Equal(*got.LastUsedAt))
+		require.NotNil(t, got.RevokedAt)
+		assert.True(t, usedAt.Add(time.Second).Equal(*got.RevokedAt))
+
+		// A key can only be revoked once
+		err = store.RevokeAPIKey(ctx, key.ID, time.Now())
+		assert.ErrorIs(t, err, auth.ErrAPIKeyNotFound)
+
+		for _, id := range []string{uuid.NewV4().String(), "not-a-uuid"} {
+			assert.ErrorIs(t, store.RevokeAPIKey(ctx, id, time.Now()), auth.ErrAPIKeyNotFound)
+			assert.ErrorIs(t, store.TouchAPIKey(ctx, id, time.Now()), auth.ErrAPIKeyNotFound)
+		}
+	})
+}
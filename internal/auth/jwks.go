@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxJWKSSize bounds how much of a JWKS response is read
+	maxJWKSSize = 1 << 20
+	// defaultMinRefreshInterval stops tokens with unknown key IDs from hammering the key server
+	defaultMinRefreshInterval = time.Minute
+	// defaultInitialBackoff is the first delay before retrying a failed refresh
+	defaultInitialBackoff = time.Second
+)
+
+// ErrUnknownKey is returned when a key ID isn't in the key set, even after refreshing it
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// publicKey is a verification key along with the algorithm it verifies
+type publicKey struct {
+	alg string
+	key interface{}
+}
+
+// KeySet is a JWKS document loaded from a file or URL and cached in memory.
+// Keys are looked up by their key ID. An unknown key ID triggers a refresh, at most
+// once per minimum refresh interval, so that rotated keys are picked up straight away.
+type KeySet struct {
+	source     string
+	client     *http.Client
+	interval   time.Duration
+	minRefresh time.Duration
+	backoff    time.Duration
+
+	// refreshMu serialises refreshes so concurrent misses share a single fetch
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+}
+
+// NewKeySet creates a KeySet reading from source, which is either an http(s) URL or a file path.
+// The keys aren't loaded until Refresh is called.
+func NewKeySet(source string, refreshInterval time.Duration) *KeySet {
+	return &KeySet{
+		source:     source,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		interval:   refreshInterval,
+		minRefresh: defaultMinRefreshInterval,
+		backoff:    defaultInitialBackoff,
+		keys:       make(map[string]publicKey),
+	}
+}
+
+// Key returns the key with the given ID, refreshing the set if it isn't known yet
+func (ks *KeySet) Key(ctx context.Context, kid string) (string, interface{}, error) {
+	if k, ok := ks.lookup(kid); ok {
+		return k.alg, k.key, nil
+	}
+
+	ks.refreshMu.Lock()
+	// Another request may have refreshed the set while we were waiting
+	k, ok := ks.lookup(kid)
+	if !ok && time.Since(ks.lastFetch()) >= ks.minRefresh {
+		if err := ks.refreshLocked(ctx); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("failed to refresh JWKS")
+		}
+		k, ok = ks.lookup(kid)
+	}
+	ks.refreshMu.Unlock()
+
+	if !ok {
+		return "", nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return k.alg, k.key, nil
+}
+
+// Refresh reloads the key set from its source, replacing the cached keys
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	ks.refreshMu.Lock()
+	defer ks.refreshMu.Unlock()
+	return ks.refreshLocked(ctx)
+}
+
+// Run refreshes the key set every refresh interval until the context is cancelled.
+// Failed refreshes are retried with exponential backoff, capped at the refresh interval,
+// and the previously loaded keys stay in use meanwhile.
+func (ks *KeySet) Run(ctx context.Context) {
+	wait := ks.interval
+	backoff := ks.backoff
+
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := ks.Refresh(ctx); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("retry_in", backoff.String()).Warn("failed to refresh JWKS")
+			wait = backoff
+			backoff *= 2
+			if backoff > ks.interval {
+				backoff = ks.interval
+			}
+			continue
+		}
+		wait = ks.interval
+		backoff = ks.backoff
+	}
+}
+
+func (ks *KeySet) lookup(kid string) (publicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+func (ks *KeySet) lastFetch() time.Time {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.fetchedAt
+}
+
+func (ks *KeySet) refreshLocked(ctx context.Context) error {
+	data, err := ks.fetch(ctx)
+	// Record the attempt even if it failed so that misses don't retry immediately
+	ks.mu.Lock()
+	ks.fetchedAt = time.Now()
+	ks.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+	return nil
+}
+
+// fetch reads the raw JWKS document from a URL or a file
+func (ks *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(ks.source, "http://") && !strings.HasPrefix(ks.source, "https://") {
+		return os.ReadFile(ks.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", ks.source, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// jwk is a single JSON Web Key as described by RFC 7517
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes a JWKS document into verification keys indexed by key ID.
+// Keys that can't be used to verify RS256 or ES256 signatures are skipped; a set with none left is an error.
+func parseJWKS(data []byte) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("could not parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			log.WithError(err).WithField("kid", k.Kid).Warn("skipping JWKS key")
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	// A set we can't use is more likely a broken document than the provider dropping every key,
+	// so it's an error, which leaves the keys already loaded in place
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (publicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" {
+			return publicKey{}, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return publicKey{}, errors.New("invalid exponent")
+		}
+		return publicKey{alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != "ES256") {
+			return publicKey{}, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return publicKey{}, errors.New("point is not on the curve")
+		}
+		return publicKey{alg: "ES256", key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
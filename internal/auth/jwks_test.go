@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "RSA", Alg: "RS256", Use: "sig", N: encodeBigInt(key.N), E: encodeBigInt(big.NewInt(int64(key.E)))}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "EC", Crv: "P-256", X: encodeBigInt(key.X), Y: encodeBigInt(key.Y)}
+}
+
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, validClaims())
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+// jwksServer serves whatever keys it currently holds and counts the requests it receives
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []jwk
+	failing  bool
+	requests int32
+}
+
+func newJWKSServer(t *testing.T, keys ...jwk) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(failing bool, keys ...jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+	s.keys = keys
+}
+
+func TestJWKSVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	server := newJWKSServer(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+
+	v, err := NewVerifier(context.Background(), config.Auth{JWKS: server.URL, JWKSRefreshInterval: time.Hour})
+	require.NoError(t, err)
+
+	t.Run("selects keys by kid", func(t *testing.T) {
+		claims, err := v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "rsa-1", rsaKey))
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject)
+
+		_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodES256, "ec-1", ecKey))
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejects a key used with another algorithm", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "ec-1", rsaKey))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("rejects tokens without a kid", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, validClaims()))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("unknown kid refreshes at most once per interval", func(t *testing.T) {
+		before := atomic.LoadInt32(&server.requests)
+		for i := 0; i < 3; i++ {
+			_, err := v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "rsa-unknown", rsaKey))
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		}
+		// the key set was loaded moments ago, so no refresh is allowed yet
+		assert.Equal(t, before, atomic.LoadInt32(&server.requests))
+	})
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := newJWKSServer(t, rsaJWK("old", &oldKey.PublicKey))
+
+	ks := NewKeySet(server.URL, time.Hour)
+	ks.minRefresh = 0
+	require.NoError(t, ks.Refresh(context.Background()))
+
+	// the provider rotates its keys; the first token signed with the new key triggers a refresh
+	server.set(false, rsaJWK("new", &newKey.PublicKey))
+	alg, key, err := ks.Key(context.Background(), "new")
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", alg)
+	assert.Equal(t, &newKey.PublicKey, key)
+
+	_, _, err = ks.Key(context.Background(), "old")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestJWKSWithoutUsableKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := newJWKSServer(t, rsaJWK("first", &key.PublicKey))
+
+	ks := NewKeySet(server.URL, time.Hour)
+	ks.minRefresh = 0
+	require.NoError(t, ks.Refresh(context.Background()))
+
+	// an empty set, or one holding only keys we can't use, fails to refresh and keeps the keys we had
+	for _, keys := range [][]jwk{nil, {{Kid: "enc", Kty: "RSA", Use: "enc"}, {Kid: "oct", Kty: "oct"}}} {
+		server.set(false, keys...)
+		assert.ErrorContains(t, ks.Refresh(context.Background()), "no usable signing keys")
+		_, _, err = ks.Key(context.Background(), "first")
+		assert.NoError(t, err)
+	}
+}
+
+func TestJWKSBackgroundRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := newJWKSServer(t, rsaJWK("first", &key.PublicKey))
+
+	ks := NewKeySet(server.URL, 50*time.Millisecond)
+	ks.minRefresh = time.Hour
+	ks.backoff = 10 * time.Millisecond
+	require.NoError(t, ks.Refresh(context.Background()))
+
+	// while the server is failing the cached keys keep working
+	server.set(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ks.Run(ctx)
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&server.requests) >= 4 }, 2*time.Second, 5*time.Millisecond)
+	_, _, err = ks.Key(context.Background(), "first")
+	assert.NoError(t, err)
+
+	server.set(false, rsaJWK("second", &key.PublicKey))
+	assert.Eventually(t, func() bool {
+		_, ok := ks.lookup("second")
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestJWKSFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	data, err := json.Marshal(map[string][]jwk{"keys": {
+		ecJWK("ec", &key.PublicKey),
+		{Kid: "enc", Kty: "RSA", Use: "enc"},
+		{Kid: "oct", Kty: "oct"},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	v, err := NewVerifier(context.Background(), config.Auth{JWKS: path, JWKSRefreshInterval: time.Hour})
+	require.NoError(t, err)
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodES256, "ec", key))
+	assert.NoError(t, err)
+
+	_, err = NewVerifier(context.Background(), config.Auth{JWKS: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
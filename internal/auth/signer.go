@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// MaxTokenTTL is the longest lifetime a token issued by the server may have
+const MaxTokenTTL = 24 * time.Hour
+
+// Signer issues tokens that the Verifier built from the same configuration accepts
+type Signer struct {
+	method   jwt.SigningMethod
+	key      interface{}
+	keyID    string
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// TokenRequest describes the token to issue
+type TokenRequest struct {
+	Subject string
+	Scopes  []string
+	Roles   []string
+	TTL     time.Duration
+}
+
+// NewSigner creates a Signer from the auth configuration. A private key file signs RS256 or
+// ES256 tokens depending on the key type; otherwise tokens are signed HS256 with the JWT secret.
+// Issued tokens carry the configured issuer and audience so that they pass verification.
+func NewSigner(cfg config.Auth) (*Signer, error) {
+	s := &Signer{
+		keyID:    cfg.SigningKeyID,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		now:      time.Now,
+	}
+
+	switch {
+	case cfg.PrivateKeyFile != "":
+		data, err := os.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read private key: %w", err)
+		}
+		s.method, s.key, err = parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not load private key %s: %w", cfg.PrivateKeyFile, err)
+		}
+	case cfg.JWTSecret != "":
+		s.method, s.key = jwt.SigningMethodHS256, []byte(cfg.JWTSecret)
+	default:
+		return nil, errors.New("no token signing key configured")
+	}
+	return s, nil
+}
+
+// Sign issues a token for the request, returning it along with its expiry
+func (s *Signer) Sign(req TokenRequest) (string, time.Time, error) {
+	if req.Subject == "" {
+		return "", time.Time{}, errors.New("a subject is required")
+	}
+	if req.TTL <= 0 || req.TTL > MaxTokenTTL {
+		return "", time.Time{}, fmt.Errorf("the lifetime must be between 1s and %s", MaxTokenTTL)
+	}
+
+	now := s.now()
+	expiresAt := now.Add(req.TTL)
+	claims := jwt.MapClaims{
+		"sub": req.Subject,
+		"iat": now.Unix(),
+		"exp": expiresAt.Unix(),
+	}
+	if len(req.Scopes) > 0 {
+		claims["scope"] = strings.Join(req.Scopes, " ")
+	}
+	if len(req.Roles) > 0 {
+		claims["roles"] = req.Roles
+	}
+	if s.issuer != "" {
+		claims["iss"] = s.issuer
+	}
+	if s.audience != "" {
+		claims["aud"] = s.audience
+	}
+
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.keyID != "" {
+		token.Header["kid"] = s.keyID
+	}
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("could not sign token: %w", err)
+	}
+	return signed, time.Unix(expiresAt.Unix(), 0), nil
+}
+
+// signingPublicKey loads a private key file and returns the algorithm of the tokens
+// it signs along with the public key that verifies them
+func signingPublicKey(path string) (string, crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read private key: %w", err)
+	}
+	method, key, err := parsePrivateKey(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not load private key %s: %w", path, err)
+	}
+	return method.Alg(), key.(crypto.Signer).Public(), nil
+}
+
+// parsePrivateKey decodes a PEM private key in PKCS #1, SEC 1 or PKCS #8 form and
+// returns the signing method it is used with along with the key itself
+func parsePrivateKey(data []byte) (jwt.SigningMethod, interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, key, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, nil, errors.New("only P-256 elliptic curve keys are supported")
+		}
+		return jwt.SigningMethodES256, key, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePrivateKey stores the PKCS #8 PEM encoding of a private key in a temporary file
+func writePrivateKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "private.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func TestSignerRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	configs := map[string]config.Auth{
+		"HS256": {JWTSecret: testSecret},
+		"RS256": {PrivateKeyFile: writePrivateKey(t, rsaKey), PublicKeyFile: writePublicKey(t, &rsaKey.PublicKey)},
+		"ES256": {PrivateKeyFile: writePrivateKey(t, ecKey), PublicKeyFile: writePublicKey(t, &ecKey.PublicKey)},
+		// Without a public key file the verifier trusts the public half of the signing key
+		"RS256 private key only": {PrivateKeyFile: writePrivateKey(t, rsaKey)},
+		"ES256 private key only": {PrivateKeyFile: writePrivateKey(t, ecKey), JWTSecret: testSecret},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.Issuer = "https://issuer.example"
+			cfg.Audience = "comments-api"
+
+			signer, err := NewSigner(cfg)
+			require.NoError(t, err)
+			verifier, err := NewVerifier(context.Background(), cfg)
+			require.NoError(t, err)
+
+			token, expiresAt, err := signer.Sign(TokenRequest{
+				Subject: "alice",
+				Scopes:  []string{ScopeCommentsWrite},
+				Roles:   []string{"moderator"},
+				TTL:     time.Hour,
+			})
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+			claims, err := verifier.Verify(context.Background(), token)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Subject)
+			assert.Equal(t, []string{ScopeCommentsWrite}, claims.Scopes)
+			assert.Equal(t, []string{"moderator"}, claims.Roles)
+			assert.True(t, expiresAt.Equal(claims.ExpiresAt))
+		})
+	}
+}
+
+func TestVerifierRejectsMismatchedKeys(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, err = NewVerifier(context.Background(), config.Auth{
+		PrivateKeyFile: writePrivateKey(t, signingKey),
+		PublicKeyFile:  writePublicKey(t, &otherKey.PublicKey),
+	})
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestSignerRejectsBadRequests(t *testing.T) {
+	signer, err := NewSigner(config.Auth{JWTSecret: testSecret})
+	require.NoError(t, err)
+
+	_, _, err = signer.Sign(TokenRequest{TTL: time.Hour})
+	assert.Error(t, err)
+	_, _, err = signer.Sign(TokenRequest{Subject: "alice"})
+	assert.Error(t, err)
+	_, _, err = signer.Sign(TokenRequest{Subject: "alice", TTL: MaxTokenTTL + time.Second})
+	assert.Error(t, err)
+
+	_, err = NewSigner(config.Auth{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Verifier checks the signature and claims of bearer tokens
+type Verifier struct {
+	// keys holds the statically configured verification key for each accepted signing algorithm
+	keys map[string]interface{}
+	// jwks, when configured, holds keys selected by the token's kid header
+	jwks     *KeySet
+	methods  []string
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewVerifier creates a Verifier from the auth configuration.
+// A JWT secret enables HS256; a public key file enables RS256 or ES256 depending on the key type,
+// as does a private key file, whose public half verifies the tokens a Signer issues with it;
+// a JWKS enables RS256 and ES256 tokens whose kid header names one of its keys, and is loaded
+// before NewVerifier returns. The signing algorithm of a token must match the key it is verified
+// with, so an RSA public key can never be used as an HMAC secret.
+func NewVerifier(ctx context.Context, cfg config.Auth) (*Verifier, error) {
+	v := &Verifier{
+		keys:     make(map[string]interface{}),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		leeway:   cfg.Leeway,
+		now:      time.Now,
+	}
+
+	if cfg.JWTSecret != "" {
+		v.keys[jwt.SigningMethodHS256.Alg()] = []byte(cfg.JWTSecret)
+	}
+
+	if cfg.PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read public key: %w", err)
+		}
+		alg, key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not load public key %s: %w", cfg.PublicKeyFile, err)
+		}
+		v.keys[alg] = key
+	}
+
+	// Tokens the server signs itself, like those from the development token endpoint,
+	// are verified with the public half of its private key
+	if cfg.PrivateKeyFile != "" {
+		alg, key, err := signingPublicKey(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if existing, ok := v.keys[alg].(interface{ Equal(crypto.PublicKey) bool }); ok && !existing.Equal(key) {
+			return nil, fmt.Errorf("public key %s does not match private key %s; both sign %s tokens",
+				cfg.PublicKeyFile, cfg.PrivateKeyFile, alg)
+		}
+		v.keys[alg] = key
+	}
+
+	for alg := range v.keys {
+		v.methods = append(v.methods, alg)
+	}
+
+	if cfg.JWKS != "" {
+		v.jwks = NewKeySet(cfg.JWKS, cfg.JWKSRefreshInterval)
+		if err := v.jwks.Refresh(ctx); err != nil {
+			return nil, fmt.Errorf("could not load JWKS: %w", err)
+		}
+		for _, alg := range []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()} {
+			if _, ok := v.keys[alg]; !ok {
+				v.methods = append(v.methods, alg)
+			}
+		}
+	}
+
+	if len(v.methods) == 0 {
+		return nil, errors.New("no token verification keys configured")
+	}
+	return v, nil
+}
+
+// Run keeps the JWKS, if any, up to date until the context is cancelled.
+// It blocks, so callers usually start it in its own goroutine.
+func (v *Verifier) Run(ctx context.Context) {
+	if v.jwks == nil {
+		return
+	}
+	v.jwks.Run(ctx)
+}
+
+// Verify checks the token's signature and claims and returns the verified claims
+func (v *Verifier) Verify(ctx context.Context, accessToken string) (Claims, error) {
+	// Time based claims are checked below so that the leeway can be applied
+	parser := jwt.Parser{ValidMethods: v.methods, UseJSONNumber: true, SkipClaimsValidation: true}
+	raw := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(accessToken, raw, func(token *jwt.Token) (interface{}, error) {
+		return v.key(ctx, token)
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return v.validateClaims(raw)
+}
+
+// key picks the key a token's signature is checked with. Tokens naming a key ID are
+// verified against the JWKS; anything else falls back to the statically configured keys.
+func (v *Verifier) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if kid, ok := token.Header["kid"].(string); ok && kid != "" && v.jwks != nil {
+		keyAlg, key, err := v.jwks.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if keyAlg != alg {
+			return nil, fmt.Errorf("key %q does not verify %s signatures", kid, alg)
+		}
+		return key, nil
+	}
+
+	key, ok := v.keys[alg]
+	if !ok {
+		return nil, fmt.Errorf("unexpected signing method %s", alg)
+	}
+	return key, nil
+}
+
+// validateClaims checks the registered claims of a token whose signature has been verified
+func (v *Verifier) validateClaims(raw jwt.MapClaims) (Claims, error) {
+	now := v.now()
+
+	exp, ok, err := timeClaim(raw, "exp")
+	if err != nil {
+		return Claims{}, err
+	}
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: token has no expiry", ErrInvalidToken)
+	}
+	if now.After(exp.Add(v.leeway)) {
+		return Claims{}, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	}
+
+	nbf, ok, err := timeClaim(raw, "nbf")
+	if err != nil {
+		return Claims{}, err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return Claims{}, fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+
+	iat, ok, err := timeClaim(raw, "iat")
+	if err != nil {
+		return Claims{}, err
+	}
+	if ok && now.Add(v.leeway).Before(iat) {
+		return Claims{}, fmt.Errorf("%w: token was issued in the future", ErrInvalidToken)
+	}
+
+	claims := Claims{
+		ExpiresAt: exp,
+		Scopes:    parseScopes(raw),
+		Roles:     parseRoles(raw),
+	}
+	claims.Subject, _ = raw["sub"].(string)
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: token has no subject", ErrInvalidToken)
+	}
+
+	claims.Issuer, _ = raw["iss"].(string)
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if a, ok := a.(string); ok {
+				claims.Audience = append(claims.Audience, a)
+			}
+		}
+	}
+	if v.audience != "" && !contains(claims.Audience, v.audience) {
+		return Claims{}, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// timeClaim reads a NumericDate claim, reporting whether it was present
+func timeClaim(raw jwt.MapClaims, name string) (time.Time, bool, error) {
+	value, ok := raw[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
+
+// parsePublicKey decodes a PEM public key or certificate and returns the
+// signing algorithm it verifies along with the key itself
+func parsePublicKey(data []byte) (string, interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", nil, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256.Alg(), key, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return "", nil, errors.New("only P-256 elliptic curve keys are supported")
+		}
+		return jwt.SigningMethodES256.Alg(), key, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
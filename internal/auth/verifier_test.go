@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "missionimpossible"
+
+// writePublicKey stores the PEM encoding of a public key in a temporary file
+func writePublicKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	require.NoError(t, err)
+	return token
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example",
+		"aud":   []string{"comments-api", "other-api"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"scope": "comments:write comments:moderate",
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("HS256", func(t *testing.T) {
+		v, err := NewVerifier(context.Background(), config.Auth{JWTSecret: testSecret})
+		require.NoError(t, err)
+
+		claims, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, []byte(testSecret), validClaims()))
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject)
+		assert.Equal(t, []string{"comments:write", "comments:moderate"}, claims.Scopes)
+		assert.True(t, claims.HasScope("comments:moderate"))
+
+		_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, []byte("wrong"), validClaims()))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("RS256", func(t *testing.T) {
+		v, err := NewVerifier(context.Background(), config.Auth{PublicKeyFile: writePublicKey(t, &rsaKey.PublicKey)})
+		require.NoError(t, err)
+
+		claims, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, validClaims()))
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject)
+
+		// HS256 isn't enabled without a secret, so the public key can't be abused as one
+		_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, []byte("secret"), validClaims()))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("ES256", func(t *testing.T) {
+		v, err := NewVerifier(context.Background(), config.Auth{JWTSecret: testSecret, PublicKeyFile: writePublicKey(t, &ecKey.PublicKey)})
+		require.NoError(t, err)
+
+		_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodES256, ecKey, validClaims()))
+		assert.NoError(t, err)
+		// the secret is still accepted alongside the public key
+		_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, []byte(testSecret), validClaims()))
+		assert.NoError(t, err)
+	})
+
+	t.Run("unsupported curve", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		_, err = NewVerifier(context.Background(), config.Auth{PublicKeyFile: writePublicKey(t, &key.PublicKey)})
+		assert.Error(t, err)
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		_, err := NewVerifier(context.Background(), config.Auth{})
+		assert.Error(t, err)
+	})
+}
+
+func TestVerifyClaims(t *testing.T) {
+	v, err := NewVerifier(context.Background(), config.Auth{
+		JWTSecret: testSecret,
+		Issuer:    "https://issuer.example",
+		Audience:  "comments-api",
+		Leeway:    time.Minute,
+	})
+	require.NoError(t, err)
+
+	verify := func(modify func(jwt.MapClaims)) error {
+		claims := validClaims()
+		modify(claims)
+		_, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims))
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		valid  bool
+	}{
+		{"valid", func(c jwt.MapClaims) {}, true},
+		{"single audience", func(c jwt.MapClaims) { c["aud"] = "comments-api" }, true},
+		{"expired within leeway", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }, true},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, false},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, false},
+		{"not valid yet within leeway", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(30 * time.Second).Unix() }, true},
+		{"not valid yet", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(2 * time.Minute).Unix() }, false},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(2 * time.Minute).Unix() }, false},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://elsewhere.example" }, false},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-api" }, false},
+		{"no audience", func(c jwt.MapClaims) { delete(c, "aud") }, false},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.modify)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, parseScopes(map[string]interface{}{"scope": " a  b "}))
+	assert.Equal(t, []string{"a", "b"}, parseScopes(map[string]interface{}{"scp": []interface{}{"a", "b"}}))
+	assert.Empty(t, parseScopes(map[string]interface{}{}))
+}
+
+func TestScopesAndRoles(t *testing.T) {
+	claims := Claims{Scopes: []string{ScopeCommentsWrite}}
+	assert.True(t, claims.HasScope(ScopeCommentsWrite))
+	assert.Equal(t, []string{ScopeCommentsModerate}, claims.MissingScopes(ScopeCommentsWrite, ScopeCommentsModerate))
+
+	moderator := Claims{Roles: []string{"moderator"}}
+	assert.Empty(t, moderator.MissingScopes(ScopeCommentsWrite, ScopeCommentsModerate))
+
+	commenter := Claims{Roles: []string{"commenter", "unknown"}}
+	assert.True(t, commenter.HasScope(ScopeCommentsWrite))
+	assert.False(t, commenter.HasScope(ScopeCommentsModerate))
+
+	assert.Equal(t, []string{"moderator"}, parseRoles(map[string]interface{}{"roles": []interface{}{"moderator"}}))
+}
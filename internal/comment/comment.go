@@ -2,84 +2,358 @@ package comment
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
 )
 
 var (
 	ErrFetchingComment = errors.New("failed to fetch comment by id")
 	ErrNotImplemented  = errors.New("not implemented")
+	ErrInvalidCursor   = InvalidFields("invalid pagination cursor", FieldError{Field: "cursor", Reason: "not a cursor returned by this API"})
+	ErrCommentNotFound = NewError(KindNotFound, "comment not found")
+	ErrParentNotFound  = InvalidFields("parent comment not found", FieldError{Field: "parent_id", Reason: "no such comment"})
+	ErrParentMismatch  = InvalidFields("parent comment belongs to a different slug", FieldError{Field: "parent_id", Reason: "must be a comment on the same slug"})
+)
+
+const (
+	// DefaultPageSize - the number of comments returned when the caller doesn't ask for a specific limit
+	DefaultPageSize = 20
+	// DefaultMaxPageSize - the largest page a caller may request unless the service is configured otherwise
+	DefaultMaxPageSize = 100
 )
 
-// Comment - a representation of the comment structure for our service
+// Comment - a representation of the comment structure for our service.
+// CreatedAt and UpdatedAt are managed by the store and ignored when passed in.
+// OwnerID is the subject of the token the comment was posted with and never changes.
+// Version starts at 1 and is bumped by the store on every update.
 type Comment struct {
-	ID     string
-	Slug   string
-	Body   string
-	Author string
+	ID        string
+	Slug      string
+	Body      string
+	Author    string
+	OwnerID   string
+	ParentID  string
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
-// Store - this interface defines all of the methods that our service needs to operate
+// Update - the changes to make to an existing comment. Fields left nil are kept as they are;
+// the ID, author, owner and timestamps of a comment can't be changed through an update.
+type Update struct {
+	Slug *string
+	Body *string
+}
+
+// IsEmpty reports whether the update leaves the comment as it is
+func (u Update) IsEmpty() bool {
+	return u.Slug == nil && u.Body == nil
+}
+
+// Store - this interface defines all of the methods that our service needs to operate.
+// UpdateComment and DeleteComment only apply when the comment is still at the given version,
+// failing with ErrVersionMismatch otherwise; a version of zero applies whatever the version.
 type Store interface {
 	GetComment(context.Context, string) (Comment, error)
 	PostComment(context.Context, Comment) (Comment, error)
-	DeleteComment(context.Context, string) error
-	UpdateComment(context.Context, string, Comment) (Comment, error)
+	DeleteComment(ctx context.Context, id string, version int) error
+	UpdateComment(ctx context.Context, id string, version int, update Update) (Comment, error)
+	ListComments(context.Context, string, Cursor, int) ([]Comment, error)
+	ListCommentTree(context.Context, TreeQuery) ([]Comment, error)
+	RestoreComment(context.Context, string) (Comment, error)
+	PurgeComments(context.Context, time.Time) (int64, error)
+	ListRevisions(context.Context, string) ([]Revision, error)
+	SearchComments(context.Context, SearchQuery) ([]SearchResult, error)
+}
+
+// Cursor - the position of the last comment a client has seen when paging through a slug.
+// Comments are always listed by creation time with the ID breaking ties, so the pair identifies the position.
+type Cursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+}
+
+// CursorAfter returns the cursor pointing just past the given comment
+func CursorAfter(c Comment) Cursor {
+	return Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+}
+
+// IsZero reports whether the cursor points at the start of the list
+func (c Cursor) IsZero() bool {
+	return c.ID == ""
+}
+
+// EncodeCursor turns a cursor into the opaque string handed out to clients
+func EncodeCursor(c Cursor) string {
+	if c.IsZero() {
+		return ""
+	}
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses an opaque cursor string previously produced by EncodeCursor.
+// An empty string decodes to the zero cursor, i.e. the first page.
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	if s == "" {
+		return c, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.IsZero() || c.CreatedAt.IsZero() {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Page - a single page of comments along with the cursor for the following page.
+// NextCursor is empty when there are no more comments to fetch.
+type Page struct {
+	Comments   []Comment
+	NextCursor string
 }
 
 // Service - is the struct on which all our logic will be built
 type Service struct {
 	Store Store
+	// DefaultPageSize is used when a caller lists comments without a limit
+	DefaultPageSize int
+	// MaxPageSize caps the limit a caller may request when listing comments
+	MaxPageSize int
+	// DefaultTreeDepth is used when a caller fetches a comment tree without a depth
+	DefaultTreeDepth int
+	// MaxTreeDepth caps how many levels of replies a single tree request may return
+	MaxTreeDepth int
+	// RequireIfMatch rejects updates and deletes that don't say which version of the comment they apply to
+	RequireIfMatch bool
+	// Metrics, when set, is told about every comment posted, updated or deleted
+	Metrics Metrics
 }
 
-// NewService - returns a pointer to a new service (kind of like a constructor method)
-func NewService(store Store) *Service {
+// Metrics - counts changes made to comments through the service
+type Metrics interface {
+	CommentPosted()
+	CommentUpdated()
+	CommentDeleted()
+}
+
+// NewService - returns a pointer to a new service (kind of like a constructor method).
+// Limits left at zero in the configuration fall back to the package defaults.
+func NewService(store Store, cfg config.Comments) *Service {
 	return &Service{
-		Store: store,
+		Store:            store,
+		DefaultPageSize:  orDefault(cfg.DefaultPageSize, DefaultPageSize),
+		MaxPageSize:      orDefault(cfg.MaxPageSize, DefaultMaxPageSize),
+		DefaultTreeDepth: orDefault(cfg.DefaultTreeDepth, DefaultTreeDepth),
+		MaxTreeDepth:     orDefault(cfg.MaxTreeDepth, DefaultMaxTreeDepth),
+		RequireIfMatch:   cfg.RequireIfMatch,
 	}
 }
 
+func orDefault(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
 // GetComment retrieves a comment by ID
 func (s *Service) GetComment(ctx context.Context, id string) (Comment, error) {
-	fmt.Println("retreiving a comment")
+	ctx, span := tracer.Start(ctx, "comment.Service.GetComment")
+	defer span.End()
+
+	logger := logging.FromContext(ctx).WithField("comment_id", id)
+	logger.Debug("retrieving a comment")
 	cmt, err := s.Store.GetComment(ctx, id)
 	if err != nil {
-		fmt.Println(err)
+		// A missing comment is the caller's concern; anything else is our own failure
+		if errors.Is(err, ErrCommentNotFound) {
+			return Comment{}, ErrCommentNotFound
+		}
+		logger.WithError(err).Error("error fetching comment")
 		return Comment{}, ErrFetchingComment
 	}
 	return cmt, nil
 }
 
-// UpdateComment updates a comment by ID.
+// UpdateComment applies an update to a comment by ID, changing only the fields the update sets.
+// Only the comment's owner or a moderator may update it, and only while it's at a version ifMatch accepts.
+// An empty update changes nothing and returns the comment as it is.
 // It invokes the Store interface's UpdateComment method to update the comment in the data store.
 func (s *Service) UpdateComment(
 	ctx context.Context,
 	ID string,
-	updatedCmt Comment,
+	update Update,
+	ifMatch IfMatch,
 ) (Comment, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.UpdateComment")
+	defer span.End()
+
+	existing, err := s.authorizeChange(ctx, ID)
+	if err != nil {
+		return Comment{}, err
+	}
+	version, err := s.checkIfMatch(ifMatch, existing)
+	if err != nil {
+		return Comment{}, err
+	}
+	if update.IsEmpty() {
+		return existing, nil
+	}
+
 	// The returned Comment object is assigned to the cmt variable, and the error (if any) is assigned to the err variable
-	cmt, err := s.Store.UpdateComment(ctx, ID, updatedCmt)
+	cmt, err := s.Store.UpdateComment(ctx, ID, version, update)
 	if err != nil {
-		fmt.Println("error updating comment")
+		logging.FromContext(ctx).WithError(err).WithField("comment_id", ID).Error("error updating comment")
 		// Returns an empty Comment object along with the received error
 		return Comment{}, err
 	}
+	if s.Metrics != nil {
+		s.Metrics.CommentUpdated()
+	}
 	// Return the updated Comment object and a nil error if there are no errors, indicating a successful update
 	return cmt, nil
 }
 
-// DeleteComment deletes a comment by ID
-func (s *Service) DeleteComment(ctx context.Context, id string) error {
+// DeleteComment soft deletes a comment by ID.
+// Only the comment's owner or a moderator may delete it, and only while it's at a version ifMatch accepts.
+// Deleted comments are hidden from every read until they are restored or purged.
+func (s *Service) DeleteComment(ctx context.Context, id string, ifMatch IfMatch) error {
+	ctx, span := tracer.Start(ctx, "comment.Service.DeleteComment")
+	defer span.End()
+
+	existing, err := s.authorizeChange(ctx, id)
+	if err != nil {
+		return err
+	}
+	version, err := s.checkIfMatch(ifMatch, existing)
+	if err != nil {
+		return err
+	}
+
 	// Call the DeleteComment method of the Store interface to delete the comment by ID
-	return s.Store.DeleteComment(ctx, id)
+	if err := s.Store.DeleteComment(ctx, id, version); err != nil {
+		return err
+	}
+	if s.Metrics != nil {
+		s.Metrics.CommentDeleted()
+	}
+	return nil
 }
 
-// PostComment creates a new comment
+// RestoreComment brings back a soft deleted comment that hasn't been purged yet
+func (s *Service) RestoreComment(ctx context.Context, id string) (Comment, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.RestoreComment")
+	defer span.End()
+
+	cmt, err := s.Store.RestoreComment(ctx, id)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("comment_id", id).Error("error restoring comment")
+		return Comment{}, err
+	}
+	return cmt, nil
+}
+
+// PurgeDeletedComments permanently removes comments that were soft deleted more than retention ago.
+// It returns the number of comments removed.
+func (s *Service) PurgeDeletedComments(ctx context.Context, retention time.Duration) (int64, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.PurgeDeletedComments")
+	defer span.End()
+
+	return s.Store.PurgeComments(ctx, time.Now().Add(-retention))
+}
+
+// PostComment creates a new comment.
+// The author and owner are the authenticated caller, whatever was passed in.
+// When the comment is a reply, the parent must exist and belong to the same slug.
 func (s *Service) PostComment(ctx context.Context, cmt Comment) (Comment, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.PostComment")
+	defer span.End()
+
+	claims, ok := auth.FromContext(ctx)
+	if !ok {
+		return Comment{}, ErrUnauthenticated
+	}
+	cmt.Author = claims.Subject
+	cmt.OwnerID = claims.Subject
+
+	if cmt.ParentID != "" {
+		parent, err := s.Store.GetComment(ctx, cmt.ParentID)
+		if err != nil {
+			if errors.Is(err, ErrCommentNotFound) {
+				return Comment{}, ErrParentNotFound
+			}
+			return Comment{}, err
+		}
+		if parent.Slug != cmt.Slug {
+			return Comment{}, ErrParentMismatch
+		}
+	}
+
 	// Call the PostComment method of the Store interface to create a new comment
 	insertedCmt, err := s.Store.PostComment(ctx, cmt)
 	if err != nil {
 		return Comment{}, err
 	}
+	if s.Metrics != nil {
+		s.Metrics.CommentPosted()
+	}
 	return insertedCmt, nil
 }
+
+// ListComments returns a page of comments for a slug, starting after the given cursor.
+// The limit is clamped to the service's MaxPageSize; a limit of zero or less means DefaultPageSize.
+func (s *Service) ListComments(ctx context.Context, slug string, cursor string, limit int) (Page, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.ListComments")
+	defer span.End()
+
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return Page{}, err
+	}
+
+	limit = s.pageSize(limit)
+
+	// Ask for one extra comment so we know whether another page follows without a second query
+	cmts, err := s.Store.ListComments(ctx, slug, after, limit+1)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("slug", slug).Error("error listing comments")
+		return Page{}, err
+	}
+
+	page := Page{Comments: cmts}
+	if len(cmts) > limit {
+		page.Comments = cmts[:limit]
+		page.NextCursor = EncodeCursor(CursorAfter(page.Comments[limit-1]))
+	}
+	if page.Comments == nil {
+		page.Comments = []Comment{}
+	}
+	return page, nil
+}
+
+// pageSize applies the service's default and maximum page sizes to a requested limit
+func (s *Service) pageSize(limit int) int {
+	if limit <= 0 {
+		limit = s.DefaultPageSize
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if s.MaxPageSize > 0 && limit > s.MaxPageSize {
+		limit = s.MaxPageSize
+	}
+	return limit
+}
@@ -0,0 +1,378 @@
+// Package commenttest holds the behaviour every comment.Store implementation must share.
+package commenttest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ptr returns a pointer to s, for setting the fields of a comment.Update
+func ptr(s string) *string {
+	return &s
+}
+
+// RunStoreTests runs the conformance suite against a comment.Store.
+// newStore is called once per sub-test; stores may be shared between calls, as every
+// sub-test works on its own slug and only asserts on the comments it created.
+func RunStoreTests(t *testing.T, newStore func(t *testing.T) comment.Store) {
+	ctx := context.Background()
+
+	// uniqueSlug keeps comments from earlier runs against a persistent store out of the results
+	uniqueSlug := func(prefix string) string {
+		return prefix + "-" + uuid.NewV4().String()
+	}
+
+	t.Run("post and get comment", func(t *testing.T) {
+		store := newStore(t)
+
+		cmt, err := store.PostComment(ctx, comment.Comment{
+			ID:      "client-chosen-id",
+			Slug:    "slug",
+			Author:  "author",
+			OwnerID: "owner",
+			Body:    "body",
+		})
+		require.NoError(t, err)
+		// The store always generates the ID itself.
+		assert.NotEqual(t, "client-chosen-id", cmt.ID)
+
+		got, err := store.GetComment(ctx, cmt.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "slug", got.Slug)
+		assert.Equal(t, "author", got.Author)
+		assert.Equal(t, "owner", got.OwnerID)
+		assert.Equal(t, "body", got.Body)
+		assert.Empty(t, got.ParentID)
+
+		// Updates only change the fields they set, and never who wrote or owns a comment
+		updated, err := store.UpdateComment(ctx, cmt.ID, 0, comment.Update{Body: ptr("edited")})
+		require.NoError(t, err)
+		assert.Equal(t, "edited", updated.Body)
+		assert.Equal(t, "slug", updated.Slug)
+		assert.Equal(t, "author", updated.Author)
+		assert.Equal(t, "owner", updated.OwnerID)
+		got, err = store.GetComment(ctx, cmt.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "edited", got.Body)
+		assert.Equal(t, "slug", got.Slug)
+		assert.Equal(t, "owner", got.OwnerID)
+
+		updated, err = store.UpdateComment(ctx, cmt.ID, 0, comment.Update{Slug: ptr("moved")})
+		require.NoError(t, err)
+		assert.Equal(t, "moved", updated.Slug)
+		assert.Equal(t, "edited", updated.Body)
+	})
+
+	t.Run("missing comments are not found", func(t *testing.T) {
+		store := newStore(t)
+
+		for _, id := range []string{uuid.NewV4().String(), "not-a-uuid"} {
+			_, err := store.GetComment(ctx, id)
+			assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+
+			_, err = store.UpdateComment(ctx, id, 0, comment.Update{Slug: ptr("slug"), Body: ptr("body")})
+			assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+
+			err = store.DeleteComment(ctx, id, 0)
+			assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+
+			_, err = store.ListRevisions(ctx, id)
+			assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+		}
+	})
+
+	t.Run("timestamps are managed by the store", func(t *testing.T) {
+		store := newStore(t)
+
+		cmt, err := store.PostComment(ctx, comment.Comment{
+			Slug:      "slug",
+			Author:    "author",
+			Body:      "body",
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+		require.NoError(t, err)
+		// Both timestamps are set on insert, ignoring whatever the caller sent.
+		assert.True(t, cmt.CreatedAt.After(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.True(t, cmt.CreatedAt.Equal(cmt.UpdatedAt))
+
+		updated, err := store.UpdateComment(ctx, cmt.ID, 0, comment.Update{
+			Slug: ptr("slug"),
+			Body: ptr("edited body"),
+		})
+		require.NoError(t, err)
+		// Only updated_at moves on update.
+		assert.Equal(t, "edited body", updated.Body)
+		assert.True(t, cmt.CreatedAt.Equal(updated.CreatedAt))
+		assert.True(t, updated.UpdatedAt.After(cmt.UpdatedAt))
+	})
+
+	t.Run("list comments page by page", func(t *testing.T) {
+		store := newStore(t)
+
+		slug := uniqueSlug("list")
+		var posted []comment.Comment
+		for i := 0; i < 4; i++ {
+			cmt, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "body"})
+			require.NoError(t, err)
+			posted = append(posted, cmt)
+		}
+		// Deleted comments are left out of the list.
+		require.NoError(t, store.DeleteComment(ctx, posted[3].ID, 0))
+
+		// Fetch the first two comments, then everything after the last one we saw.
+		first, err := store.ListComments(ctx, slug, comment.Cursor{}, 2)
+		require.NoError(t, err)
+		require.Len(t, first, 2)
+		assert.Equal(t, posted[0].ID, first[0].ID)
+		assert.Equal(t, posted[1].ID, first[1].ID)
+
+		rest, err := store.ListComments(ctx, slug, comment.CursorAfter(first[1]), 2)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Equal(t, posted[2].ID, rest[0].ID)
+	})
+
+	t.Run("list comment tree", func(t *testing.T) {
+		store := newStore(t)
+
+		slug := uniqueSlug("tree")
+		root, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "root"})
+		require.NoError(t, err)
+		reply, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "reply", ParentID: root.ID})
+		require.NoError(t, err)
+		_, err = store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "second reply", ParentID: root.ID})
+		require.NoError(t, err)
+		nested, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "nested", ParentID: reply.ID})
+		require.NoError(t, err)
+
+		// A depth of two with one reply per level stops before the nested reply.
+		cmts, err := store.ListCommentTree(ctx, comment.TreeQuery{Slug: slug, Depth: 2, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, cmts, 2)
+		assert.Equal(t, root.ID, cmts[0].ID)
+		assert.Equal(t, reply.ID, cmts[1].ID)
+
+		// Rooting the tree at a reply returns just its own replies.
+		cmts, err = store.ListCommentTree(ctx, comment.TreeQuery{Slug: slug, ParentID: reply.ID, Depth: 5, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, cmts, 1)
+		assert.Equal(t, nested.ID, cmts[0].ID)
+
+		// A reply moved to another slug isn't part of this slug's tree
+		moved, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "moved", ParentID: root.ID})
+		require.NoError(t, err)
+		_, err = store.UpdateComment(ctx, moved.ID, 0, comment.Update{Slug: ptr(uniqueSlug("tree"))})
+		require.NoError(t, err)
+		cmts, err = store.ListCommentTree(ctx, comment.TreeQuery{Slug: slug, Depth: 5, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, cmts, 4)
+		for _, cmt := range cmts {
+			assert.NotEqual(t, moved.ID, cmt.ID)
+		}
+
+		// Deleting a reply hides everything beneath it too.
+		require.NoError(t, store.DeleteComment(ctx, reply.ID, 0))
+		cmts, err = store.ListCommentTree(ctx, comment.TreeQuery{Slug: slug, Depth: 5, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, cmts, 2)
+		for _, cmt := range cmts {
+			assert.NotEqual(t, nested.ID, cmt.ID)
+		}
+	})
+
+	t.Run("delete, restore and purge comment", func(t *testing.T) {
+		store := newStore(t)
+
+		cmt, err := store.PostComment(ctx, comment.Comment{Slug: "restore-slug", Author: "jono", Body: "body"})
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteComment(ctx, cmt.ID, 0))
+
+		// A deleted comment can't be read, updated or deleted again.
+		_, err = store.GetComment(ctx, cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+		_, err = store.UpdateComment(ctx, cmt.ID, 0, comment.Update{Slug: ptr("restore-slug"), Body: ptr("body")})
+		assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+		assert.ErrorIs(t, store.DeleteComment(ctx, cmt.ID, 0), comment.ErrCommentNotFound)
+
+		// A restored comment is readable again.
+		restored, err := store.RestoreComment(ctx, cmt.ID)
+		require.NoError(t, err)
+		assert.Equal(t, cmt.ID, restored.ID)
+		_, err = store.GetComment(ctx, cmt.ID)
+		assert.NoError(t, err)
+
+		// Restoring a comment that isn't deleted is reported as not found.
+		_, err = store.RestoreComment(ctx, cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+
+		// Purging only removes comments deleted before the cut-off.
+		require.NoError(t, store.DeleteComment(ctx, cmt.ID, 0))
+		_, err = store.PurgeComments(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		_, err = store.RestoreComment(ctx, cmt.ID)
+		require.NoError(t, err)
+
+		// Once purged, a deleted comment can no longer be restored.
+		require.NoError(t, store.DeleteComment(ctx, cmt.ID, 0))
+		purged, err := store.PurgeComments(ctx, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, int64(1))
+		_, err = store.RestoreComment(ctx, cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+	})
+
+	t.Run("purging a comment purges its replies", func(t *testing.T) {
+		store := newStore(t)
+
+		slug := uniqueSlug("purge")
+		parent, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "parent"})
+		require.NoError(t, err)
+		reply, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "reply", ParentID: parent.ID})
+		require.NoError(t, err)
+		nested, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "nested", ParentID: reply.ID})
+		require.NoError(t, err)
+		sibling, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "sibling"})
+		require.NoError(t, err)
+
+		// The replies are live, but they've been hidden in the tree since their parent was deleted
+		// and would be left dangling without it, so they're purged along with it
+		require.NoError(t, store.DeleteComment(ctx, parent.ID, 0))
+		purged, err := store.PurgeComments(ctx, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, int64(3))
+
+		for _, id := range []string{parent.ID, reply.ID, nested.ID} {
+			_, err = store.GetComment(ctx, id)
+			assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+		}
+		_, err = store.GetComment(ctx, sibling.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("every write is recorded as a revision", func(t *testing.T) {
+		store := newStore(t)
+
+		cmt, err := store.PostComment(ctx, comment.Comment{Slug: "revision-slug", Author: "jono", Body: "first"})
+		require.NoError(t, err)
+		_, err = store.UpdateComment(ctx, cmt.ID, 0, comment.Update{Slug: ptr("revision-slug"), Body: ptr("second")})
+		require.NoError(t, err)
+
+		revs, err := store.ListRevisions(ctx, cmt.ID)
+		require.NoError(t, err)
+		require.Len(t, revs, 2)
+		assert.Equal(t, 1, revs[0].Revision)
+		assert.Equal(t, "first", revs[0].Body)
+		assert.Equal(t, 2, revs[1].Revision)
+		assert.Equal(t, "second", revs[1].Body)
+
+		// The history of a deleted comment is hidden along with it.
+		require.NoError(t, store.DeleteComment(ctx, cmt.ID, 0))
+		_, err = store.ListRevisions(ctx, cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+	})
+
+	t.Run("search comments", func(t *testing.T) {
+		store := newStore(t)
+
+		slug := uniqueSlug("search")
+		// The word only shows up in this test's comments, so other comments in a shared store don't match
+		word := "zebra" + strings.ReplaceAll(uuid.NewV4().String(), "-", "")[:8]
+		once, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "A " + word + " crossed the road."})
+		require.NoError(t, err)
+		twice, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "elliot", Body: word + " after " + word})
+		require.NoError(t, err)
+		_, err = store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "nothing to see here"})
+		require.NoError(t, err)
+		deleted, err := store.PostComment(ctx, comment.Comment{Slug: slug, Author: "jono", Body: "a deleted " + word})
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteComment(ctx, deleted.ID, 0))
+
+		// Deleted and non-matching comments are left out and the closest match comes first
+		results, err := store.SearchComments(ctx, comment.SearchQuery{Text: word, Slug: slug, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, twice.ID, results[0].ID)
+		assert.Equal(t, once.ID, results[1].ID)
+		assert.Greater(t, results[0].Rank, results[1].Rank)
+		assert.Contains(t, results[1].Snippet, comment.HighlightStart+word+comment.HighlightStop)
+
+		// Pages are taken in rank order
+		results, err = store.SearchComments(ctx, comment.SearchQuery{Text: word, Slug: slug, Offset: 1, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, once.ID, results[0].ID)
+
+		// Filters narrow the search down
+		results, err = store.SearchComments(ctx, comment.SearchQuery{Text: word, Author: "jono", Slug: slug, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, once.ID, results[0].ID)
+
+		results, err = store.SearchComments(ctx, comment.SearchQuery{Text: word, Slug: slug, From: twice.CreatedAt, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, twice.ID, results[0].ID)
+
+		results, err = store.SearchComments(ctx, comment.SearchQuery{Text: word, Slug: slug, To: twice.CreatedAt, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, once.ID, results[0].ID)
+
+		results, err = store.SearchComments(ctx, comment.SearchQuery{Text: word, Slug: uniqueSlug("search"), Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, results)
+
+		// Snippets are HTML, so markup in a body is escaped rather than passed through
+		markup := uniqueSlug("search")
+		_, err = store.PostComment(ctx, comment.Comment{Slug: markup, Author: "jono", Body: `<script>alert(1)</script> <b>` + word + `</b>`})
+		require.NoError(t, err)
+		results, err = store.SearchComments(ctx, comment.SearchQuery{Text: word, Slug: markup, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.NotContains(t, results[0].Snippet, "<script>")
+		assert.NotContains(t, results[0].Snippet, "<b>")
+		assert.Contains(t, results[0].Snippet, "&lt;script&gt;")
+		assert.Contains(t, results[0].Snippet, comment.HighlightStart+word+comment.HighlightStop)
+	})
+
+	t.Run("writes can be made conditional on the version", func(t *testing.T) {
+		store := newStore(t)
+
+		cmt, err := store.PostComment(ctx, comment.Comment{Slug: "version-slug", Author: "jono", Body: "first"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, cmt.Version)
+
+		// Every update bumps the version, whether or not it asked for one
+		updated, err := store.UpdateComment(ctx, cmt.ID, 0, comment.Update{Body: ptr("second")})
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated.Version)
+		updated, err = store.UpdateComment(ctx, cmt.ID, 2, comment.Update{Body: ptr("third")})
+		require.NoError(t, err)
+		assert.Equal(t, 3, updated.Version)
+		got, err := store.GetComment(ctx, cmt.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 3, got.Version)
+
+		// Writes against an older version change nothing
+		_, err = store.UpdateComment(ctx, cmt.ID, 2, comment.Update{Body: ptr("lost update")})
+		assert.ErrorIs(t, err, comment.ErrVersionMismatch)
+		assert.ErrorIs(t, store.DeleteComment(ctx, cmt.ID, 2), comment.ErrVersionMismatch)
+		got, err = store.GetComment(ctx, cmt.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "third", got.Body)
+
+		require.NoError(t, store.DeleteComment(ctx, cmt.ID, 3))
+
+		// A comment that's gone is missing whatever the version
+		_, err = store.UpdateComment(ctx, cmt.ID, 3, comment.Update{Body: ptr("too late")})
+		assert.ErrorIs(t, err, comment.ErrCommentNotFound)
+		assert.ErrorIs(t, store.DeleteComment(ctx, cmt.ID, 3), comment.ErrCommentNotFound)
+	})
+}
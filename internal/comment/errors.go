@@ -0,0 +1,82 @@
+package comment
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrorKind - the category of a domain error, used by transports to pick a status code
+type ErrorKind int
+
+const (
+	// KindInternal is any failure the caller can't do anything about
+	KindInternal ErrorKind = iota
+	// KindNotFound means the requested comment or revision doesn't exist
+	KindNotFound
+	// KindInvalid means the caller sent input the service won't accept
+	KindInvalid
+	// KindConflict means the request clashes with the current state of a comment
+	KindConflict
+	// KindUnauthorized means the caller isn't allowed to perform the operation
+	KindUnauthorized
+	// KindForbidden means the caller is known but may not touch this particular comment
+	KindForbidden
+	// KindPreconditionFailed means the comment isn't at the version the caller expected
+	KindPreconditionFailed
+	// KindPreconditionRequired means the caller must say which version of the comment it expects
+	KindPreconditionRequired
+)
+
+// FieldError - a problem with a single field of the caller's input
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+// Error - a domain error carrying its kind, a message safe to show to callers,
+// and optionally the fields that failed validation and the underlying cause.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if len(e.Fields) > 0 {
+		reasons := make([]string, 0, len(e.Fields))
+		for _, f := range e.Fields {
+			reasons = append(reasons, f.Field+": "+f.Reason)
+		}
+		msg += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns a domain error of the given kind
+func NewError(kind ErrorKind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// InvalidFields returns an invalid input error listing the fields that were rejected
+func InvalidFields(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindInvalid, Message: message, Fields: fields}
+}
+
+// KindOf reports the kind of the first domain error in err's chain.
+// Errors that aren't domain errors are internal.
+func KindOf(err error) ErrorKind {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Kind
+	}
+	return KindInternal
+}
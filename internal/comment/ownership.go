@@ -0,0 +1,39 @@
+package comment
+
+import (
+	"context"
+	"errors"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+)
+
+var (
+	ErrUnauthenticated = NewError(KindUnauthorized, "authentication required")
+	ErrNotOwner        = NewError(KindForbidden, "only the author of a comment or a moderator may change it")
+)
+
+// authorizeChange fetches a comment the caller wants to change and checks that they own it.
+// Moderators may change any comment. Comments posted before ownership was recorded have
+// no owner, so only moderators can change them.
+func (s *Service) authorizeChange(ctx context.Context, id string) (Comment, error) {
+	claims, ok := auth.FromContext(ctx)
+	if !ok {
+		return Comment{}, ErrUnauthenticated
+	}
+
+	cmt, err := s.Store.GetComment(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return Comment{}, ErrCommentNotFound
+		}
+		return Comment{}, err
+	}
+
+	if claims.HasScope(auth.ScopeCommentsModerate) {
+		return cmt, nil
+	}
+	if cmt.OwnerID == "" || cmt.OwnerID != claims.Subject {
+		return Comment{}, ErrNotOwner
+	}
+	return cmt, nil
+}
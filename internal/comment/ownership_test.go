@@ -0,0 +1,66 @@
+package comment_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func as(subject string, scopes ...string) context.Context {
+	return auth.NewContext(context.Background(), auth.Claims{Subject: subject, Scopes: scopes})
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestOwnership(t *testing.T) {
+	store := memory.NewStore()
+	svc := comment.NewService(store, config.Comments{})
+
+	_, err := svc.PostComment(context.Background(), comment.Comment{Slug: "slug", Body: "anonymous"})
+	assert.ErrorIs(t, err, comment.ErrUnauthenticated)
+
+	cmt, err := svc.PostComment(as("alice"), comment.Comment{Slug: "slug", Author: "mallory", OwnerID: "mallory", Body: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", cmt.Author)
+	assert.Equal(t, "alice", cmt.OwnerID)
+
+	t.Run("others cannot change the comment", func(t *testing.T) {
+		_, err := svc.UpdateComment(as("bob"), cmt.ID, comment.Update{Body: ptr("edited")}, comment.IfMatch{})
+		assert.ErrorIs(t, err, comment.ErrNotOwner)
+		assert.Equal(t, comment.KindForbidden, comment.KindOf(err))
+
+		err = svc.DeleteComment(as("bob"), cmt.ID, comment.IfMatch{})
+		assert.ErrorIs(t, err, comment.ErrNotOwner)
+	})
+
+	t.Run("the owner keeps authorship when editing", func(t *testing.T) {
+		updated, err := svc.UpdateComment(as("alice"), cmt.ID, comment.Update{Body: ptr("edited")}, comment.IfMatch{})
+		require.NoError(t, err)
+		assert.Equal(t, "alice", updated.Author)
+		assert.Equal(t, "edited", updated.Body)
+	})
+
+	t.Run("moderators can change any comment", func(t *testing.T) {
+		_, err := svc.UpdateComment(as("carol", auth.ScopeCommentsModerate), cmt.ID, comment.Update{Body: ptr("moderated")}, comment.IfMatch{})
+		require.NoError(t, err)
+
+		modCtx := auth.NewContext(context.Background(), auth.Claims{Subject: "dave", Roles: []string{"moderator"}})
+		require.NoError(t, svc.DeleteComment(modCtx, cmt.ID, comment.IfMatch{}))
+	})
+
+	t.Run("comments without an owner are left to moderators", func(t *testing.T) {
+		legacy, err := store.PostComment(context.Background(), comment.Comment{Slug: "slug", Author: "legacy", Body: "old"})
+		require.NoError(t, err)
+
+		err = svc.DeleteComment(as(""), legacy.ID, comment.IfMatch{})
+		assert.ErrorIs(t, err, comment.ErrNotOwner)
+	})
+}
@@ -0,0 +1,31 @@
+package comment
+
+import (
+	"context"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+)
+
+// RunPurgeJob purges soft deleted comments older than retention every interval.
+// It blocks until the context is cancelled, so callers usually start it in its own goroutine.
+func (s *Service) RunPurgeJob(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeDeletedComments(ctx, retention)
+			if err != nil {
+				logging.FromContext(ctx).WithError(err).Error("failed to purge deleted comments")
+				continue
+			}
+			if purged > 0 {
+				logging.FromContext(ctx).WithField("purged", purged).Info("purged deleted comments")
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+)
+
+var (
+	ErrRevisionNotFound = NewError(KindNotFound, "comment revision not found")
+)
+
+// Revision - a snapshot of a comment's content as it was after a create or an update.
+// Revisions are numbered from 1, the comment as originally posted.
+type Revision struct {
+	CommentID string
+	Revision  int
+	Slug      string
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// Diff operations used in a LineDiff
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// LineDiff - a single line of a body diff and whether it was kept, added or removed
+type LineDiff struct {
+	Op   string
+	Text string
+}
+
+// FieldChange - a single-line field whose value differs between two revisions
+type FieldChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+// RevisionDiff - the differences between two revisions of the same comment
+type RevisionDiff struct {
+	CommentID string
+	From      int
+	To        int
+	Changes   []FieldChange
+	Body      []LineDiff
+}
+
+// ListRevisions returns every revision of a comment, oldest first
+func (s *Service) ListRevisions(ctx context.Context, id string) ([]Revision, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.ListRevisions")
+	defer span.End()
+
+	revs, err := s.Store.ListRevisions(ctx, id)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("comment_id", id).Error("error listing comment revisions")
+		return nil, err
+	}
+	return revs, nil
+}
+
+// DiffRevisions compares two revisions of a comment, showing how to get from one to the other
+func (s *Service) DiffRevisions(ctx context.Context, id string, from, to int) (RevisionDiff, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.DiffRevisions")
+	defer span.End()
+
+	revs, err := s.ListRevisions(ctx, id)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	fromRev, ok := findRevision(revs, from)
+	if !ok {
+		return RevisionDiff{}, revisionNotFound(from)
+	}
+	toRev, ok := findRevision(revs, to)
+	if !ok {
+		return RevisionDiff{}, revisionNotFound(to)
+	}
+
+	diff := RevisionDiff{
+		CommentID: id,
+		From:      from,
+		To:        to,
+		Changes:   []FieldChange{},
+		Body:      diffLines(fromRev.Body, toRev.Body),
+	}
+	if fromRev.Slug != toRev.Slug {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "slug", From: fromRev.Slug, To: toRev.Slug})
+	}
+	if fromRev.Author != toRev.Author {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "author", From: fromRev.Author, To: toRev.Author})
+	}
+	return diff, nil
+}
+
+// revisionNotFound names the missing revision while still matching ErrRevisionNotFound
+func revisionNotFound(number int) error {
+	return &Error{
+		Kind:    KindNotFound,
+		Message: fmt.Sprintf("revision %d not found", number),
+		Err:     ErrRevisionNotFound,
+	}
+}
+
+func findRevision(revs []Revision, number int) (Revision, bool) {
+	for _, rev := range revs {
+		if rev.Revision == number {
+			return rev, true
+		}
+	}
+	return Revision{}, false
+}
+
+// maxDiffCells caps the size of the table diffLines builds to find the longest common subsequence,
+// which grows with the product of the two bodies' line counts
+const maxDiffCells = 1 << 20
+
+// diffLines produces a line based diff of two bodies using their longest common subsequence.
+// Lines the bodies start and end with are kept as they are; if what's left between them is
+// too big to compare line by line, it's shown as deleted from one body and inserted in the other.
+func diffLines(from, to string) []LineDiff {
+	a := strings.Split(from, "\n")
+	b := strings.Split(to, "\n")
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]LineDiff, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		diff = append(diff, LineDiff{Op: DiffEqual, Text: line})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(middleA)+1)*(len(middleB)+1) > maxDiffCells {
+		for _, line := range middleA {
+			diff = append(diff, LineDiff{Op: DiffDelete, Text: line})
+		}
+		for _, line := range middleB {
+			diff = append(diff, LineDiff{Op: DiffInsert, Text: line})
+		}
+	} else {
+		diff = append(diff, lcsDiff(middleA, middleB)...)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, LineDiff{Op: DiffEqual, Text: line})
+	}
+	return diff
+}
+
+// lcsDiff diffs two sets of lines by building the table of their longest common subsequences
+func lcsDiff(a, b []string) []LineDiff {
+	// lcs[i][j] holds the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]LineDiff, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, LineDiff{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, LineDiff{Op: DiffDelete, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, LineDiff{Op: DiffInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, LineDiff{Op: DiffDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, LineDiff{Op: DiffInsert, Text: b[j]})
+	}
+	return diff
+}
@@ -0,0 +1,65 @@
+package comment_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := comment.NewService(store, config.Comments{})
+
+	diff := func(t *testing.T, from, to string) []comment.LineDiff {
+		t.Helper()
+		cmt, err := store.PostComment(ctx, comment.Comment{Slug: "slug", Author: "jono", Body: from})
+		require.NoError(t, err)
+		_, err = store.UpdateComment(ctx, cmt.ID, 0, comment.Update{Body: &to})
+		require.NoError(t, err)
+		d, err := svc.DiffRevisions(ctx, cmt.ID, 1, 2)
+		require.NoError(t, err)
+		return d.Body
+	}
+	count := func(lines []comment.LineDiff, op string) int {
+		n := 0
+		for _, line := range lines {
+			if line.Op == op {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("lines are matched up", func(t *testing.T) {
+		assert.Equal(t, []comment.LineDiff{
+			{Op: comment.DiffEqual, Text: "a"},
+			{Op: comment.DiffDelete, Text: "b"},
+			{Op: comment.DiffEqual, Text: "c"},
+			{Op: comment.DiffInsert, Text: "d"},
+			{Op: comment.DiffEqual, Text: "e"},
+		}, diff(t, "a\nb\nc\ne", "a\nc\nd\ne"))
+	})
+
+	t.Run("large bodies are compared without a full table", func(t *testing.T) {
+		var from, to []string
+		for i := 0; i < 2000; i++ {
+			from = append(from, fmt.Sprintf("old %d", i))
+			to = append(to, fmt.Sprintf("new %d", i))
+		}
+		body := diff(t, "first\n"+strings.Join(from, "\n")+"\nlast", "first\n"+strings.Join(to, "\n")+"\nlast")
+
+		require.Len(t, body, 4002)
+		assert.Equal(t, comment.LineDiff{Op: comment.DiffEqual, Text: "first"}, body[0])
+		assert.Equal(t, comment.LineDiff{Op: comment.DiffEqual, Text: "last"}, body[len(body)-1])
+		assert.Equal(t, 2000, count(body, comment.DiffDelete))
+		assert.Equal(t, 2000, count(body, comment.DiffInsert))
+	})
+}
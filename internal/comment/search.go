@@ -0,0 +1,329 @@
+package comment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+)
+
+const (
+	// MaxSearchLength - the longest search text, in characters, a caller may send
+	MaxSearchLength = 256
+	// HighlightStart and HighlightStop wrap the matching words in a search snippet
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+	// snippetWords - the most words a snippet built by MatchComments holds
+	snippetWords = 35
+	// snippetLead - how many words before the first match a snippet built by MatchComments starts
+	snippetLead = 5
+)
+
+// SearchQuery - the text to search comments for along with the optional filters narrowing the search.
+// From and To bound the creation time of the comments returned: From is inclusive, To exclusive,
+// and either may be left zero. Results are ordered by rank, best first; Offset and Limit select a page of them.
+type SearchQuery struct {
+	Text   string
+	Slug   string
+	Author string
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+// SearchResult - a comment matching a search, how well it matched and an extract of its body.
+// Rank is only meaningful relative to the other results of the same search.
+// Snippet is HTML: an extract of the comment's body, escaped so it's safe to render,
+// with the matching words wrapped in HighlightStart and HighlightStop.
+type SearchResult struct {
+	Comment
+	Rank    float64
+	Snippet string
+}
+
+// SearchPage - a single page of search results along with the cursor for the following page.
+// NextCursor is empty when there are no more results to fetch.
+type SearchPage struct {
+	Results    []SearchResult
+	NextCursor string
+}
+
+// searchCursor - the position of a page of search results.
+// Results are ordered by rank rather than by a unique key, so the position is an offset.
+type searchCursor struct {
+	Offset int `json:"offset"`
+}
+
+// encodeSearchCursor turns an offset into the opaque string handed out to clients
+func encodeSearchCursor(offset int) string {
+	raw, err := json.Marshal(searchCursor{Offset: offset})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeSearchCursor parses a cursor previously produced by encodeSearchCursor.
+// An empty string decodes to the first page.
+func decodeSearchCursor(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	var c searchCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Offset <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return c.Offset, nil
+}
+
+// SearchComments returns a page of the comments matching a search, best match first, starting at the given cursor.
+// The limit is clamped to the service's MaxPageSize; a limit of zero or less means DefaultPageSize.
+// The query's Offset and Limit are ignored in favour of the cursor and limit.
+func (s *Service) SearchComments(ctx context.Context, q SearchQuery, cursor string, limit int) (SearchPage, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.SearchComments")
+	defer span.End()
+
+	offset, err := decodeSearchCursor(cursor)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	if err := validateSearch(q); err != nil {
+		return SearchPage{}, err
+	}
+
+	limit = s.pageSize(limit)
+	q.Offset = offset
+	// As with ListComments, one extra result tells us whether another page follows
+	q.Limit = limit + 1
+
+	results, err := s.Store.SearchComments(ctx, q)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("error searching comments")
+		return SearchPage{}, err
+	}
+
+	page := SearchPage{Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		page.NextCursor = encodeSearchCursor(offset + limit)
+	}
+	if page.Results == nil {
+		page.Results = []SearchResult{}
+	}
+	return page, nil
+}
+
+// validateSearch reports every problem with a search query
+func validateSearch(q SearchQuery) error {
+	var fields []FieldError
+	text := strings.TrimSpace(q.Text)
+	switch {
+	case text == "":
+		fields = append(fields, FieldError{Field: "q", Reason: "is required"})
+	case utf8.RuneCountInString(text) > MaxSearchLength:
+		fields = append(fields, FieldError{Field: "q", Reason: fmt.Sprintf("must be at most %d characters", MaxSearchLength)})
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.To.After(q.From) {
+		fields = append(fields, FieldError{Field: "to", Reason: "must be after from"})
+	}
+	if len(fields) > 0 {
+		return InvalidFields("invalid search", fields...)
+	}
+	return nil
+}
+
+// MatchComments searches a set of comments without help from a database, for stores that have
+// no full-text search of their own. Every word of the query text must appear in a comment's body,
+// ignoring case and simple word endings such as plurals, while words prefixed with "-" must not.
+// Comments are ranked by how often the words appear, ties going to the newest comment, and the
+// query's filters, Offset and Limit are applied. Deleted comments must already have been left out.
+func MatchComments(cmts []Comment, q SearchQuery) []SearchResult {
+	include, exclude := parseSearchText(q.Text)
+	if len(include) == 0 {
+		return nil
+	}
+
+	var results []SearchResult
+	for _, cmt := range cmts {
+		if !matchesFilters(cmt, q) {
+			continue
+		}
+
+		counts := make(map[string]int)
+		for _, word := range searchWords(cmt.Body) {
+			counts[word]++
+		}
+		matches := 0
+		for _, term := range include {
+			if counts[term] == 0 {
+				matches = 0
+				break
+			}
+			matches += counts[term]
+		}
+		for _, term := range exclude {
+			if counts[term] > 0 {
+				matches = 0
+			}
+		}
+		if matches == 0 {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			Comment: cmt,
+			Rank:    float64(matches),
+			Snippet: snippet(cmt.Body, include),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	if q.Offset >= len(results) {
+		return nil
+	}
+	results = results[q.Offset:]
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results
+}
+
+// matchesFilters reports whether a comment passes a search's slug, author and date filters
+func matchesFilters(cmt Comment, q SearchQuery) bool {
+	if q.Slug != "" && cmt.Slug != q.Slug {
+		return false
+	}
+	if q.Author != "" && cmt.Author != q.Author {
+		return false
+	}
+	if !q.From.IsZero() && cmt.CreatedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !cmt.CreatedAt.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// parseSearchText splits search text into the words a comment must and must not contain
+func parseSearchText(text string) (include, exclude []string) {
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "-") {
+			exclude = append(exclude, searchWords(field)...)
+			continue
+		}
+		include = append(include, searchWords(field)...)
+	}
+	return include, exclude
+}
+
+// searchWords breaks text into normalised words, dropping punctuation
+func searchWords(text string) []string {
+	words := strings.FieldsFunc(text, isWordSeparator)
+	for i, word := range words {
+		words[i] = normaliseWord(word)
+	}
+	return words
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// normaliseWord lowercases a word and strips a common English ending,
+// so that "jumps", "jumped" and "jumping" all match "jump"
+func normaliseWord(word string) string {
+	word = strings.ToLower(word)
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(word) > len(suffix)+2 && strings.HasSuffix(word, suffix) {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// snippet returns a run of words from body starting just before the first match as HTML,
+// escaping the words so that markup in the body is shown rather than run, and highlighting every match
+func snippet(body string, terms []string) string {
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	isMatch := func(field string) bool {
+		for _, word := range searchWords(field) {
+			if wanted[word] {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := strings.Fields(body)
+	start := 0
+	for i, field := range fields {
+		if isMatch(field) {
+			start = i - snippetLead
+			break
+		}
+	}
+	if start < 0 || len(fields) <= snippetWords {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(fields) {
+		end = len(fields)
+	}
+
+	out := make([]string, 0, end-start)
+	for _, field := range fields[start:end] {
+		out = append(out, highlight(field, wanted))
+	}
+	return strings.Join(out, " ")
+}
+
+// highlight escapes a field of a body as HTML and wraps each of its words that's wanted in a highlight,
+// leaving punctuation and markup around the words outside of it
+func highlight(field string, wanted map[string]bool) string {
+	var b strings.Builder
+	for len(field) > 0 {
+		// Take a run of word characters, or else a run of separators
+		first, _ := utf8.DecodeRuneInString(field)
+		inWord := !isWordSeparator(first)
+		end := strings.IndexFunc(field, func(r rune) bool { return isWordSeparator(r) == inWord })
+		if end < 0 {
+			end = len(field)
+		}
+		run := field[:end]
+		field = field[end:]
+
+		if inWord && wanted[normaliseWord(run)] {
+			b.WriteString(HighlightStart + html.EscapeString(run) + HighlightStop)
+			continue
+		}
+		b.WriteString(html.EscapeString(run))
+	}
+	return b.String()
+}
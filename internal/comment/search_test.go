@@ -0,0 +1,100 @@
+package comment_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchComments(t *testing.T) {
+	now := time.Now()
+	cmts := []comment.Comment{
+		{ID: "1", Slug: "a", Author: "alice", Body: "The quick brown fox jumps over the lazy dog.", CreatedAt: now},
+		{ID: "2", Slug: "a", Author: "bob", Body: "Foxes jumped, and the fox kept jumping!", CreatedAt: now.Add(time.Second)},
+		{ID: "3", Slug: "b", Author: "alice", Body: "A lazy afternoon", CreatedAt: now.Add(2 * time.Second)},
+	}
+	ids := func(results []comment.SearchResult) []string {
+		var got []string
+		for _, r := range results {
+			got = append(got, r.ID)
+		}
+		return got
+	}
+
+	t.Run("every word must match, ignoring case and endings", func(t *testing.T) {
+		assert.Equal(t, []string{"2", "1"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "FOX jump"})))
+		assert.Equal(t, []string{"1"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "lazy fox"})))
+		assert.Empty(t, comment.MatchComments(cmts, comment.SearchQuery{Text: "fox cat"}))
+		assert.Empty(t, comment.MatchComments(cmts, comment.SearchQuery{Text: "  !? "}))
+	})
+
+	t.Run("excluded words", func(t *testing.T) {
+		assert.Equal(t, []string{"3"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "lazy -dog"})))
+	})
+
+	t.Run("filters and pages", func(t *testing.T) {
+		assert.Equal(t, []string{"3"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "lazy", Slug: "b"})))
+		assert.Equal(t, []string{"1"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "fox", Author: "alice"})))
+		assert.Equal(t, []string{"2"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "fox", From: now.Add(time.Second)})))
+		assert.Equal(t, []string{"1"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "fox", To: now.Add(time.Second)})))
+		assert.Equal(t, []string{"1"}, ids(comment.MatchComments(cmts, comment.SearchQuery{Text: "fox", Offset: 1, Limit: 1})))
+		assert.Empty(t, comment.MatchComments(cmts, comment.SearchQuery{Text: "fox", Offset: 2}))
+	})
+
+	t.Run("snippets highlight the matching words", func(t *testing.T) {
+		results := comment.MatchComments(cmts, comment.SearchQuery{Text: "fox"})
+		require.Len(t, results, 2)
+		assert.Equal(t, "<mark>Foxes</mark> jumped, and the <mark>fox</mark> kept jumping!", results[0].Snippet)
+
+		long := strings.Repeat("filler ", 50) + "needle " + strings.Repeat("filler ", 50)
+		results = comment.MatchComments([]comment.Comment{{ID: "4", Body: long}}, comment.SearchQuery{Text: "needle"})
+		require.Len(t, results, 1)
+		assert.True(t, strings.HasPrefix(results[0].Snippet, "filler filler filler filler filler <mark>needle</mark> filler"))
+		assert.Len(t, strings.Fields(results[0].Snippet), 35)
+	})
+
+	t.Run("snippets escape markup in the body", func(t *testing.T) {
+		body := `<script>alert("xss")</script> <b>bold</b> & 'quoted'`
+		results := comment.MatchComments([]comment.Comment{{ID: "5", Body: body}}, comment.SearchQuery{Text: "bold"})
+		require.Len(t, results, 1)
+		assert.Equal(t,
+			`&lt;script&gt;alert(&#34;xss&#34;)&lt;/script&gt; &lt;b&gt;<mark>bold</mark>&lt;/b&gt; &amp; &#39;quoted&#39;`,
+			results[0].Snippet,
+		)
+	})
+}
+
+func TestSearchComments(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := comment.NewService(store, config.Comments{})
+	for i := 0; i < 3; i++ {
+		_, err := store.PostComment(ctx, comment.Comment{Slug: "slug", Author: "jono", Body: "searchable"})
+		require.NoError(t, err)
+	}
+
+	first, err := svc.SearchComments(ctx, comment.SearchQuery{Text: "searchable"}, "", 2)
+	require.NoError(t, err)
+	assert.Len(t, first.Results, 2)
+	require.NotEmpty(t, first.NextCursor)
+
+	rest, err := svc.SearchComments(ctx, comment.SearchQuery{Text: "searchable"}, first.NextCursor, 2)
+	require.NoError(t, err)
+	assert.Len(t, rest.Results, 1)
+	assert.Empty(t, rest.NextCursor)
+
+	_, err = svc.SearchComments(ctx, comment.SearchQuery{Text: "searchable"}, "not-a-cursor", 2)
+	assert.ErrorIs(t, err, comment.ErrInvalidCursor)
+
+	_, err = svc.SearchComments(ctx, comment.SearchQuery{Text: " ", From: time.Now(), To: time.Now().Add(-time.Hour)}, "", 0)
+	assert.Equal(t, comment.KindInvalid, comment.KindOf(err))
+	assert.Contains(t, err.Error(), "q: is required")
+	assert.Contains(t, err.Error(), "to: must be after from")
+}
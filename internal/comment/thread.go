@@ -0,0 +1,120 @@
+package comment
+
+import (
+	"context"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+)
+
+const (
+	// DefaultTreeDepth - the number of levels returned when the caller doesn't ask for a specific depth
+	DefaultTreeDepth = 3
+	// DefaultMaxTreeDepth - the deepest tree a caller may request unless the service is configured otherwise
+	DefaultMaxTreeDepth = 10
+)
+
+// TreeQuery - describes which part of a slug's comment tree a store should load.
+// The store returns every comment of the tree as a flat list; Limit applies to each
+// level independently, so every parent contributes at most Limit of its replies.
+type TreeQuery struct {
+	Slug string
+	// ParentID roots the tree at a comment's replies; empty means the top-level comments
+	ParentID string
+	// After is applied to the first level only
+	After Cursor
+	Depth int
+	Limit int
+}
+
+// Thread - a comment along with a page of its replies
+type Thread struct {
+	Comment
+	Replies []Thread
+	// NextCursor fetches the following page of replies when passed back with the comment's ID as the parent
+	NextCursor string
+}
+
+// Tree - a page of threads rooted at a slug or at a single comment
+type Tree struct {
+	Comments   []Thread
+	NextCursor string
+}
+
+// GetCommentTree returns a slug's comments as a tree, starting from the top-level comments
+// or, when parentID is set, from that comment's replies.
+// Each level holds at most limit comments and the tree is at most depth levels deep.
+func (s *Service) GetCommentTree(
+	ctx context.Context,
+	slug string,
+	parentID string,
+	cursor string,
+	depth int,
+	limit int,
+) (Tree, error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.GetCommentTree")
+	defer span.End()
+
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return Tree{}, err
+	}
+
+	if depth <= 0 {
+		depth = s.DefaultTreeDepth
+	}
+	if depth <= 0 {
+		depth = DefaultTreeDepth
+	}
+	if s.MaxTreeDepth > 0 && depth > s.MaxTreeDepth {
+		depth = s.MaxTreeDepth
+	}
+	limit = s.pageSize(limit)
+
+	// As with ListComments, one extra comment per level tells us whether that level has another page
+	cmts, err := s.Store.ListCommentTree(ctx, TreeQuery{
+		Slug:     slug,
+		ParentID: parentID,
+		After:    after,
+		Depth:    depth,
+		Limit:    limit + 1,
+	})
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("error fetching comment tree")
+		return Tree{}, err
+	}
+
+	return buildTree(cmts, parentID, limit), nil
+}
+
+// buildTree assembles a flat list of comments into threads hanging off rootID.
+// Replies keep the order in which the store returned them.
+func buildTree(cmts []Comment, rootID string, limit int) Tree {
+	children := make(map[string][]Comment)
+	for _, cmt := range cmts {
+		children[cmt.ParentID] = append(children[cmt.ParentID], cmt)
+	}
+
+	var build func(parentID string) ([]Thread, string)
+	build = func(parentID string) ([]Thread, string) {
+		level := children[parentID]
+		var next string
+		if len(level) > limit {
+			level = level[:limit]
+			next = EncodeCursor(CursorAfter(level[limit-1]))
+		}
+
+		threads := make([]Thread, 0, len(level))
+		for _, cmt := range level {
+			replies, repliesCursor := build(cmt.ID)
+			threads = append(threads, Thread{
+				Comment:    cmt,
+				Replies:    replies,
+				NextCursor: repliesCursor,
+			})
+		}
+		return threads, next
+	}
+
+	threads, next := build(rootID)
+	return Tree{Comments: threads, NextCursor: next}
+}
@@ -0,0 +1,9 @@
+package comment
+
+import (
+	"go.opentelemetry.io/otel"
+)
+
+// tracer starts the spans of the service methods, which sit between the HTTP request span
+// and the spans of the store calls they make
+var tracer = otel.Tracer("github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment")
@@ -0,0 +1,38 @@
+package comment
+
+var (
+	ErrVersionMismatch      = NewError(KindPreconditionFailed, "comment has changed since it was fetched")
+	ErrPreconditionRequired = NewError(KindPreconditionRequired, "the version of the comment being changed is required")
+)
+
+// IfMatch - the versions of a comment a caller is willing to change, as sent in an If-Match header.
+// The zero value means the caller didn't ask for a check.
+type IfMatch struct {
+	// Set is true whenever the caller sent a precondition, even one no version can match
+	Set bool
+	// Any matches whatever version the comment is at
+	Any bool
+	// Versions lists the versions the comment must be at for the change to go ahead
+	Versions []int
+}
+
+// checkIfMatch applies a caller's precondition to the current state of the comment it wants to change.
+// It returns the version the store must still find the comment at when making the change,
+// which is zero when any version will do.
+func (s *Service) checkIfMatch(ifMatch IfMatch, cmt Comment) (int, error) {
+	if !ifMatch.Set {
+		if s.RequireIfMatch {
+			return 0, ErrPreconditionRequired
+		}
+		return 0, nil
+	}
+	if ifMatch.Any {
+		return 0, nil
+	}
+	for _, version := range ifMatch.Versions {
+		if version == cmt.Version {
+			return version, nil
+		}
+	}
+	return 0, ErrVersionMismatch
+}
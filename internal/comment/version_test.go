@@ -0,0 +1,46 @@
+package comment_test
+
+import (
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfMatch(t *testing.T) {
+	svc := comment.NewService(memory.NewStore(), config.Comments{})
+	cmt, err := svc.PostComment(as("alice"), comment.Comment{Slug: "slug", Body: "first"})
+	require.NoError(t, err)
+
+	t.Run("changes need a version the comment is at", func(t *testing.T) {
+		_, err := svc.UpdateComment(as("alice"), cmt.ID, comment.Update{Body: ptr("stale")}, comment.IfMatch{Set: true, Versions: []int{2, 3}})
+		assert.ErrorIs(t, err, comment.ErrVersionMismatch)
+		assert.Equal(t, comment.KindPreconditionFailed, comment.KindOf(err))
+
+		// A header with no usable tags can't match any version
+		err = svc.DeleteComment(as("alice"), cmt.ID, comment.IfMatch{Set: true})
+		assert.ErrorIs(t, err, comment.ErrVersionMismatch)
+
+		updated, err := svc.UpdateComment(as("alice"), cmt.ID, comment.Update{Body: ptr("second")}, comment.IfMatch{Set: true, Versions: []int{3, 1}})
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated.Version)
+
+		updated, err = svc.UpdateComment(as("alice"), cmt.ID, comment.Update{Body: ptr("third")}, comment.IfMatch{Set: true, Any: true})
+		require.NoError(t, err)
+		assert.Equal(t, 3, updated.Version)
+	})
+
+	t.Run("a version can be required", func(t *testing.T) {
+		strict := comment.NewService(svc.Store, config.Comments{RequireIfMatch: true})
+
+		_, err := strict.UpdateComment(as("alice"), cmt.ID, comment.Update{Body: ptr("unconditional")}, comment.IfMatch{})
+		assert.ErrorIs(t, err, comment.ErrPreconditionRequired)
+		assert.Equal(t, comment.KindPreconditionRequired, comment.KindOf(err))
+		assert.ErrorIs(t, strict.DeleteComment(as("alice"), cmt.ID, comment.IfMatch{}), comment.ErrPreconditionRequired)
+
+		require.NoError(t, strict.DeleteComment(as("alice"), cmt.ID, comment.IfMatch{Set: true, Versions: []int{3}}))
+	})
+}
@@ -0,0 +1,346 @@
+// Package config loads the server configuration.
+//
+// Settings are resolved in increasing order of precedence:
+//
+//  1. built-in defaults (see Default)
+//  2. the YAML file named by the -config flag or the CONFIG_FILE environment variable
+//  3. environment variables
+//  4. command-line flags
+//
+// so a flag always wins over an environment variable, which always wins over the file.
+// Every setting, with its YAML key, environment variable and flag, is listed in settings.go.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete configuration of the server
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Comments Comments `yaml:"comments"`
+	Auth     Auth     `yaml:"auth"`
+	Tracing  Tracing  `yaml:"tracing"`
+	Logging  Logging  `yaml:"logging"`
+}
+
+// Server configures the HTTP server
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr string `yaml:"addr"`
+	// RequestTimeout bounds how long a single request may take
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainDelay is how long readiness fails on shutdown before the server stops accepting
+	// connections, giving load balancers time to stop sending it traffic
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// HealthCheckTimeout bounds how long each dependency check behind /healthz and /readyz may take
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout"`
+}
+
+// Database configures where comments are stored
+type Database struct {
+	// Backend is one of postgres, sqlite or memory
+	Backend  string `yaml:"backend"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"ssl_mode"`
+	// SQLitePath is the database file used by the sqlite backend
+	SQLitePath string `yaml:"sqlite_path"`
+	// ConnectTimeout is how long startup keeps retrying to connect to postgres; zero means a single attempt
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// ConnectBackoff is the delay before the first retry, doubled after each failed attempt
+	ConnectBackoff time.Duration `yaml:"connect_backoff"`
+	// SkipMigrations stops the server from migrating the schema when it starts, for deployments
+	// that run "migrate up" as a separate step
+	SkipMigrations bool `yaml:"skip_migrations"`
+	// IgnoreMigrationErrors lets the server start even when the schema couldn't be migrated
+	IgnoreMigrationErrors bool `yaml:"ignore_migration_errors"`
+}
+
+// Comments configures the comment service
+type Comments struct {
+	DefaultPageSize  int `yaml:"default_page_size"`
+	MaxPageSize      int `yaml:"max_page_size"`
+	DefaultTreeDepth int `yaml:"default_tree_depth"`
+	MaxTreeDepth     int `yaml:"max_tree_depth"`
+	// PurgeInterval is how often soft deleted comments are checked for purging
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+	// PurgeRetention is how long soft deleted comments are kept before being purged
+	PurgeRetention time.Duration `yaml:"purge_retention"`
+	// RequireIfMatch rejects changes to a comment that don't say which version of it they apply to
+	RequireIfMatch bool `yaml:"require_if_match"`
+}
+
+// Auth configures how requests are authenticated
+type Auth struct {
+	// JWTSecret is the HMAC key HS256 bearer tokens are signed with
+	JWTSecret string `yaml:"jwt_secret"`
+	// PublicKeyFile is a PEM encoded RSA or P-256 public key (or certificate)
+	// used to verify RS256 or ES256 bearer tokens
+	PublicKeyFile string `yaml:"public_key_file"`
+	// JWKS is the URL or file path of a JSON Web Key Set used to verify RS256 or ES256
+	// tokens by their kid header
+	JWKS string `yaml:"jwks"`
+	// JWKSRefreshInterval is how often the JWKS is reloaded
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval"`
+	// Issuer, when set, must match the iss claim of every token
+	Issuer string `yaml:"issuer"`
+	// Audience, when set, must be one of the aud claims of every token
+	Audience string `yaml:"audience"`
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration `yaml:"leeway"`
+	// PrivateKeyFile is a PEM encoded RSA or P-256 private key used to sign tokens issued by
+	// the server itself. Without it, issued tokens are signed with JWTSecret.
+	PrivateKeyFile string `yaml:"private_key_file"`
+	// SigningKeyID is put in the kid header of issued tokens
+	SigningKeyID string `yaml:"signing_key_id"`
+	// DevTokenEndpoint enables POST /api/v1/auth/token, which hands out tokens to anyone who asks.
+	// It is meant for local development and tests only.
+	DevTokenEndpoint bool `yaml:"dev_token_endpoint"`
+}
+
+// Tracing configures where OpenTelemetry traces are exported to
+type Tracing struct {
+	// Exporter is one of none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// OTLPEndpoint is the host:port of the collector the otlp exporter sends traces to over HTTP
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	// OTLPInsecure sends traces over plain HTTP rather than HTTPS, e.g. to a local collector
+	OTLPInsecure bool `yaml:"otlp_insecure"`
+	// ServiceName identifies the server in the exported traces
+	ServiceName string `yaml:"service_name"`
+}
+
+// Logging configures the structured logger
+type Logging struct {
+	// Level is the least severe level logged: trace, debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is text or json
+	Format string `yaml:"format"`
+}
+
+// Formats that Logging.Format may name
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// logLevels are the levels Logging.Level may name
+var logLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+// Exporters that Tracing.Exporter may name
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Backends that Database.Backend may name
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+// Default returns the configuration used when nothing overrides it
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:               "0.0.0.0:8080",
+			RequestTimeout:     15 * time.Second,
+			ShutdownTimeout:    15 * time.Second,
+			HealthCheckTimeout: 2 * time.Second,
+		},
+		Database: Database{
+			Backend:        BackendPostgres,
+			Host:           "localhost",
+			Port:           5432,
+			SSLMode:        "require",
+			SQLitePath:     "comments.db",
+			ConnectTimeout: 30 * time.Second,
+			ConnectBackoff: 500 * time.Millisecond,
+		},
+		Comments: Comments{
+			DefaultPageSize:  20,
+			MaxPageSize:      100,
+			DefaultTreeDepth: 3,
+			MaxTreeDepth:     10,
+			PurgeInterval:    time.Hour,
+			PurgeRetention:   30 * 24 * time.Hour,
+		},
+		Auth: Auth{
+			JWKSRefreshInterval: 15 * time.Minute,
+			Leeway:              30 * time.Second,
+		},
+		Tracing: Tracing{
+			Exporter:     ExporterNone,
+			OTLPEndpoint: "localhost:4318",
+			ServiceName:  "go-rest-api-course",
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: FormatText,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the config file, the environment and
+// the given command-line arguments (usually os.Args[1:]), in that order of precedence.
+// Load only reports settings it can't parse; call Validate to check the result makes sense.
+func Load(args []string) (Config, error) {
+	return LoadFlagSet(flag.NewFlagSet("server", flag.ContinueOnError), args)
+}
+
+// LoadFlagSet is Load for commands with flags of their own: they are registered on fs
+// beforehand and parsed along with the configuration flags.
+func LoadFlagSet(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (env CONFIG_FILE)")
+	flagValues := make(map[string]*flagValue, len(settings))
+	for _, s := range settings {
+		flagValues[s.flag] = &flagValue{isBool: s.isBool()}
+		fs.Var(flagValues[s.flag], s.flag, fmt.Sprintf("%s (env %s, yaml %s)", s.usage, strings.Join(s.env, " or "), s.key))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		for _, env := range s.env {
+			raw, ok := os.LookupEnv(env)
+			if !ok {
+				continue
+			}
+			if err := s.set(raw); err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %w", env, err)
+			}
+			break
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := findSetting(settings, f.Name)
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := s.set(flagValues[f.Name].raw); err != nil {
+			flagErr = fmt.Errorf("invalid -%s: %w", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	return cfg, nil
+}
+
+// loadFile overlays the settings found in a YAML file. Unknown keys are rejected
+// so that a typo doesn't silently leave a setting at its default.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable, reporting every problem at once
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.Addr)
+	check(err == nil, "server.addr must be host:port, got %q", c.Server.Addr)
+	check(c.Server.RequestTimeout > 0, "server.request_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.Server.HealthCheckTimeout > 0, "server.health_check_timeout must be positive")
+
+	switch c.Database.Backend {
+	case BackendPostgres:
+		check(c.Database.Host != "", "database.host is required for the postgres backend")
+		check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port must be between 1 and 65535")
+		check(c.Database.Username != "", "database.username is required for the postgres backend")
+		check(c.Database.Name != "", "database.name is required for the postgres backend")
+		check(c.Database.ConnectTimeout >= 0, "database.connect_timeout must not be negative")
+		check(c.Database.ConnectBackoff > 0, "database.connect_backoff must be positive")
+	case BackendSQLite:
+		check(c.Database.SQLitePath != "", "database.sqlite_path is required for the sqlite backend")
+	case BackendMemory:
+	default:
+		check(false, "database.backend must be one of %s, %s or %s, got %q",
+			BackendPostgres, BackendSQLite, BackendMemory, c.Database.Backend)
+	}
+
+	check(c.Comments.DefaultPageSize > 0, "comments.default_page_size must be positive")
+	check(c.Comments.MaxPageSize >= c.Comments.DefaultPageSize, "comments.max_page_size must be at least comments.default_page_size")
+	check(c.Comments.DefaultTreeDepth > 0, "comments.default_tree_depth must be positive")
+	check(c.Comments.MaxTreeDepth >= c.Comments.DefaultTreeDepth, "comments.max_tree_depth must be at least comments.default_tree_depth")
+	check(c.Comments.PurgeInterval > 0, "comments.purge_interval must be positive")
+	check(c.Comments.PurgeRetention > 0, "comments.purge_retention must be positive")
+
+	check(c.Auth.JWTSecret != "" || c.Auth.PublicKeyFile != "" || c.Auth.JWKS != "",
+		"one of auth.jwt_secret, auth.public_key_file or auth.jwks is required")
+	check(c.Auth.JWKS == "" || c.Auth.JWKSRefreshInterval > 0, "auth.jwks_refresh_interval must be positive")
+	check(c.Auth.Leeway >= 0, "auth.leeway must not be negative")
+	check(!c.Auth.DevTokenEndpoint || c.Auth.JWTSecret != "" || c.Auth.PrivateKeyFile != "",
+		"auth.dev_token_endpoint needs auth.jwt_secret or auth.private_key_file to sign tokens with")
+
+	switch c.Tracing.Exporter {
+	case ExporterNone, ExporterStdout:
+	case ExporterOTLP:
+		check(c.Tracing.OTLPEndpoint != "", "tracing.otlp_endpoint is required for the otlp exporter")
+	default:
+		check(false, "tracing.exporter must be one of %s, %s or %s, got %q",
+			ExporterNone, ExporterStdout, ExporterOTLP, c.Tracing.Exporter)
+	}
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+
+	validLevel := false
+	for _, level := range logLevels {
+		validLevel = validLevel || c.Logging.Level == level
+	}
+	check(validLevel, "logging.level must be one of %s, got %q", strings.Join(logLevels, ", "), c.Logging.Level)
+	check(c.Logging.Format == FormatText || c.Logging.Format == FormatJSON,
+		"logging.format must be %s or %s, got %q", FormatText, FormatJSON, c.Logging.Format)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: "127.0.0.1:9000"
+  request_timeout: 5s
+database:
+  host: file-host
+  port: 6543
+comments:
+  max_page_size: 50
+`)
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("DB_PORT", "7654")
+
+	cfg, err := Load([]string{"-config", path, "-db-port", "8765"})
+	require.NoError(t, err)
+
+	// defaults survive when nothing overrides them
+	assert.Equal(t, 15*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, 20, cfg.Comments.DefaultPageSize)
+	// the file overrides the defaults
+	assert.Equal(t, "127.0.0.1:9000", cfg.Server.Addr)
+	assert.Equal(t, 5*time.Second, cfg.Server.RequestTimeout)
+	assert.Equal(t, 50, cfg.Comments.MaxPageSize)
+	// the environment overrides the file
+	assert.Equal(t, "env-host", cfg.Database.Host)
+	// flags override the environment
+	assert.Equal(t, 8765, cfg.Database.Port)
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	path := writeConfigFile(t, "database:\n  backend: memory\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, BackendMemory, cfg.Database.Backend)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Run("unknown key in config file", func(t *testing.T) {
+		path := writeConfigFile(t, "server:\n  adr: \":8080\"\n")
+		_, err := Load([]string{"-config", path})
+		assert.ErrorContains(t, err, "adr")
+	})
+
+	t.Run("unparsable environment variable", func(t *testing.T) {
+		t.Setenv("SERVER_REQUEST_TIMEOUT", "soon")
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "SERVER_REQUEST_TIMEOUT")
+	})
+
+	t.Run("unparsable flag", func(t *testing.T) {
+		_, err := Load([]string{"-max-page-size", "lots"})
+		assert.ErrorContains(t, err, "-max-page-size")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid configuration", func(t *testing.T) {
+		cfg := Default()
+		cfg.Database.Backend = BackendMemory
+		cfg.Auth.JWTSecret = "secret"
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("reports every problem", func(t *testing.T) {
+		cfg := Default()
+		cfg.Server.Addr = "nope"
+		cfg.Comments.MaxPageSize = 1
+
+		err := cfg.Validate()
+		require.Error(t, err)
+		for _, want := range []string{
+			"server.addr",
+			"database.username",
+			"database.name",
+			"comments.max_page_size",
+			"auth.jwt_secret, auth.public_key_file or auth.jwks",
+		} {
+			assert.True(t, strings.Contains(err.Error(), want), "expected %q in %q", want, err)
+		}
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		cfg := Default()
+		cfg.Database.Backend = "mongo"
+		cfg.Auth.JWTSecret = "secret"
+		assert.ErrorContains(t, cfg.Validate(), "database.backend")
+	})
+
+	t.Run("unknown tracing exporter", func(t *testing.T) {
+		cfg := Default()
+		cfg.Database.Backend = BackendMemory
+		cfg.Auth.JWTSecret = "secret"
+		cfg.Tracing.Exporter = "zipkin"
+		assert.ErrorContains(t, cfg.Validate(), "tracing.exporter")
+	})
+
+	t.Run("unknown log level and format", func(t *testing.T) {
+		cfg := Default()
+		cfg.Database.Backend = BackendMemory
+		cfg.Auth.JWTSecret = "secret"
+		cfg.Logging.Level = "loud"
+		cfg.Logging.Format = "xml"
+		err := cfg.Validate()
+		assert.ErrorContains(t, err, "logging.level")
+		assert.ErrorContains(t, err, "logging.format")
+	})
+}
+
+func TestLoadBoolSetting(t *testing.T) {
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	assert.False(t, cfg.Auth.DevTokenEndpoint)
+
+	t.Setenv("AUTH_DEV_TOKEN_ENDPOINT", "true")
+	cfg, err = Load(nil)
+	require.NoError(t, err)
+	assert.True(t, cfg.Auth.DevTokenEndpoint)
+
+	// a bare flag switches the setting on; an explicit value switches it off again
+	cfg, err = Load([]string{"-dev-token-endpoint=false"})
+	require.NoError(t, err)
+	assert.False(t, cfg.Auth.DevTokenEndpoint)
+
+	t.Setenv("AUTH_DEV_TOKEN_ENDPOINT", "false")
+	cfg, err = Load([]string{"-dev-token-endpoint"})
+	require.NoError(t, err)
+	assert.True(t, cfg.Auth.DevTokenEndpoint)
+
+	t.Setenv("AUTH_DEV_TOKEN_ENDPOINT", "maybe")
+	_, err = Load(nil)
+	assert.ErrorContains(t, err, "AUTH_DEV_TOKEN_ENDPOINT")
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// setting ties a configuration field to its YAML key, environment variables and flag
+type setting struct {
+	key   string
+	env   []string
+	flag  string
+	usage string
+	// target points at the field in the Config being loaded
+	target interface{}
+}
+
+// settings lists every setting that can be overridden from the environment or the command line.
+// Where a setting has more than one environment variable, the first one that is set wins.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", []string{"SERVER_ADDR"}, "addr", "address to listen on", &c.Server.Addr},
+		{"server.request_timeout", []string{"SERVER_REQUEST_TIMEOUT"}, "request-timeout", "maximum duration of a request", &c.Server.RequestTimeout},
+		{"server.shutdown_timeout", []string{"SERVER_SHUTDOWN_TIMEOUT"}, "shutdown-timeout", "time allowed for in-flight requests on shutdown", &c.Server.ShutdownTimeout},
+		{"server.drain_delay", []string{"SERVER_DRAIN_DELAY"}, "drain-delay", "how long readiness fails on shutdown before connections are refused", &c.Server.DrainDelay},
+		{"server.health_check_timeout", []string{"SERVER_HEALTH_CHECK_TIMEOUT"}, "health-check-timeout", "time allowed for each health check", &c.Server.HealthCheckTimeout},
+
+		{"database.backend", []string{"STORE_BACKEND"}, "store-backend", "comment store: postgres, sqlite or memory", &c.Database.Backend},
+		{"database.host", []string{"DB_HOST"}, "db-host", "postgres host", &c.Database.Host},
+		{"database.port", []string{"DB_PORT"}, "db-port", "postgres port", &c.Database.Port},
+		{"database.username", []string{"DB_USERNAME"}, "db-username", "postgres user", &c.Database.Username},
+		{"database.password", []string{"DB_PASSWORD"}, "db-password", "postgres password", &c.Database.Password},
+		{"database.name", []string{"DB_NAME", "DB_TABLE"}, "db-name", "postgres database name", &c.Database.Name},
+		{"database.ssl_mode", []string{"SSL_MODE"}, "db-ssl-mode", "postgres sslmode", &c.Database.SSLMode},
+		{"database.sqlite_path", []string{"SQLITE_PATH"}, "sqlite-path", "sqlite database file", &c.Database.SQLitePath},
+		{"database.connect_timeout", []string{"DB_CONNECT_TIMEOUT"}, "db-connect-timeout", "how long to keep retrying to connect to postgres on startup", &c.Database.ConnectTimeout},
+		{"database.connect_backoff", []string{"DB_CONNECT_BACKOFF"}, "db-connect-backoff", "delay before the first connection retry, doubled after each attempt", &c.Database.ConnectBackoff},
+		{"database.skip_migrations", []string{"DB_SKIP_MIGRATIONS"}, "skip-migrations", "don't migrate the schema on startup", &c.Database.SkipMigrations},
+		{"database.ignore_migration_errors", []string{"DB_IGNORE_MIGRATION_ERRORS"}, "ignore-migration-errors", "start even if the schema can't be migrated", &c.Database.IgnoreMigrationErrors},
+
+		{"comments.default_page_size", []string{"COMMENTS_DEFAULT_PAGE_SIZE"}, "default-page-size", "comments per page when no limit is given", &c.Comments.DefaultPageSize},
+		{"comments.max_page_size", []string{"COMMENTS_MAX_PAGE_SIZE"}, "max-page-size", "largest page a client may request", &c.Comments.MaxPageSize},
+		{"comments.default_tree_depth", []string{"COMMENTS_DEFAULT_TREE_DEPTH"}, "default-tree-depth", "reply levels returned when no depth is given", &c.Comments.DefaultTreeDepth},
+		{"comments.max_tree_depth", []string{"COMMENTS_MAX_TREE_DEPTH"}, "max-tree-depth", "deepest tree a client may request", &c.Comments.MaxTreeDepth},
+		{"comments.purge_interval", []string{"COMMENT_PURGE_INTERVAL"}, "purge-interval", "how often deleted comments are purged", &c.Comments.PurgeInterval},
+		{"comments.purge_retention", []string{"COMMENT_PURGE_RETENTION"}, "purge-retention", "how long deleted comments can be restored", &c.Comments.PurgeRetention},
+		{"comments.require_if_match", []string{"COMMENTS_REQUIRE_IF_MATCH"}, "require-if-match", "reject comment changes without an If-Match header", &c.Comments.RequireIfMatch},
+
+		{"auth.jwt_secret", []string{"JWT_SECRET"}, "jwt-secret", "HMAC key used to verify HS256 bearer tokens", &c.Auth.JWTSecret},
+		{"auth.public_key_file", []string{"JWT_PUBLIC_KEY_FILE"}, "jwt-public-key-file", "PEM public key used to verify RS256 or ES256 bearer tokens", &c.Auth.PublicKeyFile},
+		{"auth.jwks", []string{"JWT_JWKS"}, "jwt-jwks", "URL or file of a JWKS used to verify RS256 or ES256 bearer tokens", &c.Auth.JWKS},
+		{"auth.jwks_refresh_interval", []string{"JWT_JWKS_REFRESH_INTERVAL"}, "jwt-jwks-refresh-interval", "how often the JWKS is reloaded", &c.Auth.JWKSRefreshInterval},
+		{"auth.issuer", []string{"JWT_ISSUER"}, "jwt-issuer", "required iss claim of bearer tokens", &c.Auth.Issuer},
+		{"auth.audience", []string{"JWT_AUDIENCE"}, "jwt-audience", "required aud claim of bearer tokens", &c.Auth.Audience},
+		{"auth.private_key_file", []string{"JWT_PRIVATE_KEY_FILE"}, "jwt-private-key-file", "PEM private key used to sign issued RS256 or ES256 tokens", &c.Auth.PrivateKeyFile},
+		{"auth.signing_key_id", []string{"JWT_SIGNING_KEY_ID"}, "jwt-signing-key-id", "kid header of issued tokens", &c.Auth.SigningKeyID},
+		{"auth.dev_token_endpoint", []string{"AUTH_DEV_TOKEN_ENDPOINT"}, "dev-token-endpoint", "serve POST /api/v1/auth/token for local development", &c.Auth.DevTokenEndpoint},
+		{"auth.leeway", []string{"JWT_LEEWAY"}, "jwt-leeway", "clock skew tolerated when checking token times", &c.Auth.Leeway},
+
+		{"logging.level", []string{"LOG_LEVEL"}, "log-level", "least severe level logged: trace, debug, info, warn or error", &c.Logging.Level},
+		{"logging.format", []string{"LOG_FORMAT"}, "log-format", "log format: text or json", &c.Logging.Format},
+
+		{"tracing.exporter", []string{"TRACING_EXPORTER"}, "tracing-exporter", "where traces are sent: none, stdout or otlp", &c.Tracing.Exporter},
+		{"tracing.otlp_endpoint", []string{"TRACING_OTLP_ENDPOINT"}, "tracing-otlp-endpoint", "host:port of the OTLP/HTTP collector", &c.Tracing.OTLPEndpoint},
+		{"tracing.otlp_insecure", []string{"TRACING_OTLP_INSECURE"}, "tracing-otlp-insecure", "send traces to the collector over plain HTTP", &c.Tracing.OTLPInsecure},
+		{"tracing.service_name", []string{"TRACING_SERVICE_NAME", "OTEL_SERVICE_NAME"}, "tracing-service-name", "service name reported in traces", &c.Tracing.ServiceName},
+	}
+}
+
+// isBool reports whether the setting is a switch that may be given as a bare flag
+func (s setting) isBool() bool {
+	_, ok := s.target.(*bool)
+	return ok
+}
+
+// flagValue holds the raw value of a setting's flag until the file and environment have been applied
+type flagValue struct {
+	raw    string
+	isBool bool
+}
+
+func (f *flagValue) String() string { return f.raw }
+
+func (f *flagValue) Set(raw string) error {
+	f.raw = raw
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be switched on with a bare -flag
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+func findSetting(settings []setting, flagName string) (setting, bool) {
+	for _, s := range settings {
+		if s.flag == flagName {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// set parses raw into the setting's field according to the field's type
+func (s setting) set(raw string) error {
+	switch target := s.target.(type) {
+	case *string:
+		*target = raw
+	case *int:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		*target = v
+	case *bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		*target = v
+	case *time.Duration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 1h", raw)
+		}
+		*target = v
+	default:
+		return fmt.Errorf("unsupported setting type %T", s.target)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	uuid "github.com/satori/go.uuid"
+)
+
+// apiKeyColumns lists the columns selected whenever an API key is read
+const apiKeyColumns = `id, name, prefix, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+// APIKeyRow models the columns within the api_keys table in the database
+type APIKeyRow struct {
+	ID         string
+	Name       string
+	Prefix     string
+	KeyHash    string `db:"key_hash"`
+	Scopes     string
+	CreatedBy  string       `db:"created_by"`
+	CreatedAt  time.Time    `db:"created_at"`
+	ExpiresAt  sql.NullTime `db:"expires_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func fromNullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func convertAPIKeyRowToAPIKey(r APIKeyRow) auth.APIKey {
+	return auth.APIKey{
+		ID:         r.ID,
+		Name:       r.Name,
+		Prefix:     r.Prefix,
+		Scopes:     strings.Fields(r.Scopes),
+		CreatedBy:  r.CreatedBy,
+		CreatedAt:  r.CreatedAt,
+		ExpiresAt:  fromNullTime(r.ExpiresAt),
+		LastUsedAt: fromNullTime(r.LastUsedAt),
+		RevokedAt:  fromNullTime(r.RevokedAt),
+	}
+}
+
+// CreateAPIKey stores a new API key, generating its ID and creation time
+func (d *Database) CreateAPIKey(ctx context.Context, key auth.APIKey, hash string) (auth.APIKey, error) {
+	row := APIKeyRow{
+		ID:        uuid.NewV4().String(),
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(key.Scopes, " "),
+		CreatedBy: key.CreatedBy,
+		ExpiresAt: nullTime(key.ExpiresAt),
+	}
+
+	stmt, err := d.Client.PrepareNamedContext(
+		ctx,
+		`INSERT INTO api_keys
+		(id, name, prefix, key_hash, scopes, created_by, created_at, expires_at)
+		VALUES
+		(:id, :name, :prefix, :key_hash, :scopes, :created_by, now(), :expires_at)
+		RETURNING `+apiKeyColumns,
+	)
+	if err != nil {
+		return auth.APIKey{}, fmt.Errorf("failed to insert api key: %w", err)
+	}
+	defer stmt.Close()
+
+	var inserted APIKeyRow
+	if err := stmt.GetContext(ctx, &inserted, row); err != nil {
+		return auth.APIKey{}, fmt.Errorf("failed to insert api key: %w", err)
+	}
+	return convertAPIKeyRowToAPIKey(inserted), nil
+}
+
+// GetAPIKeyByHash returns the key whose secret hashes to hash
+func (d *Database) GetAPIKeyByHash(ctx context.Context, hash string) (auth.APIKey, error) {
+	var row APIKeyRow
+	err := d.Client.GetContext(
+		ctx,
+		&row,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`,
+		hash,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.APIKey{}, fmt.Errorf("failed to fetch api key: %w", auth.ErrAPIKeyNotFound)
+		}
+		return auth.APIKey{}, fmt.Errorf("failed to fetch api key: %w", err)
+	}
+	return convertAPIKeyRowToAPIKey(row), nil
+}
+
+// ListAPIKeys returns every key in creation order
+func (d *Database) ListAPIKeys(ctx context.Context) ([]auth.APIKey, error) {
+	var rows []APIKeyRow
+	err := d.Client.SelectContext(
+		ctx,
+		&rows,
+		`SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at ASC, id ASC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	keys := make([]auth.APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, convertAPIKeyRowToAPIKey(row))
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks a key that hasn't been revoked yet as revoked
+func (d *Database) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	if !isValidID(id) {
+		return fmt.Errorf("failed to revoke api key: %w", auth.ErrAPIKeyNotFound)
+	}
+	res, err := d.Client.ExecContext(
+		ctx,
+		`UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		at,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return expectOneRow(res, "failed to revoke api key")
+}
+
+// TouchAPIKey records when a key was last used
+func (d *Database) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	if !isValidID(id) {
+		return fmt.Errorf("failed to record api key use: %w", auth.ErrAPIKeyNotFound)
+	}
+	res, err := d.Client.ExecContext(
+		ctx,
+		`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`,
+		at,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record api key use: %w", err)
+	}
+	return expectOneRow(res, "failed to record api key use")
+}
+
+// expectOneRow turns an update that matched no API key into ErrAPIKeyNotFound
+func expectOneRow(res sql.Result, msg string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", msg, auth.ErrAPIKeyNotFound)
+	}
+	return nil
+}
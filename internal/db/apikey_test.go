@@ -0,0 +1,23 @@
+//go:build integration
+// +build integration
+
+package db
+
+import (
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth/authtest"
+)
+
+// TestAPIKeyDatabaseConformance runs the shared auth.KeyStore suite against Postgres.
+func TestAPIKeyDatabaseConformance(t *testing.T) {
+	db, err := NewDatabase(testConfig(t))
+	if err != nil {
+		t.Fatalf("could not connect to the database: %v", err)
+	}
+
+	authtest.RunKeyStoreTests(t, func(t *testing.T) auth.KeyStore {
+		return db
+	})
+}
@@ -3,119 +3,344 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid" // assign the name 'uuid' to the package as an alias
 )
 
+// commentColumns lists the columns selected whenever a full comment is read
+const commentColumns = `id, slug, body, author, owner_id, parent_id, version, created_at, updated_at`
+
 // CommentRow models the columns within comments table in the database
 type CommentRow struct {
-	ID     string
-	Slug   sql.NullString
-	Body   sql.NullString
-	Author sql.NullString
+	ID        string
+	Slug      sql.NullString
+	Body      sql.NullString
+	Author    sql.NullString
+	OwnerID   string         `db:"owner_id"`
+	ParentID  sql.NullString `db:"parent_id"`
+	Version   int            `db:"version"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
 }
 
 func convertCommentRowToComment(c CommentRow) comment.Comment {
 	return comment.Comment{
-		ID:     c.ID,
-		Slug:   c.Slug.String,
-		Author: c.Author.String,
-		Body:   c.Body.String,
+		ID:        c.ID,
+		Slug:      c.Slug.String,
+		Author:    c.Author.String,
+		OwnerID:   c.OwnerID,
+		Body:      c.Body.String,
+		ParentID:  c.ParentID.String,
+		Version:   c.Version,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
 	}
 }
 
+func convertCommentRowsToComments(rows []CommentRow) []comment.Comment {
+	cmts := make([]comment.Comment, 0, len(rows))
+	for _, row := range rows {
+		cmts = append(cmts, convertCommentRowToComment(row))
+	}
+	return cmts
+}
+
+// isValidID reports whether id could be a comment ID at all.
+// Postgres rejects malformed UUIDs with an error, which callers should see as a missing comment.
+func isValidID(id string) bool {
+	_, err := uuid.FromString(id)
+	return err == nil
+}
+
 func (d *Database) GetComment(
 	ctx context.Context,
-	uuid string,
+	id string,
 ) (comment.Comment, error) {
-	var cmtRow CommentRow
-	_, err := d.Client.ExecContext(ctx, "SELECT pg_sleep(16)")
-	if err != nil {
-		return comment.Comment{}, err
+	if !isValidID(id) {
+		return comment.Comment{}, fmt.Errorf("error fetching the comment by uuid: %w", comment.ErrCommentNotFound)
 	}
 
-	row := d.Client.QueryRowContext(
+	var cmtRow CommentRow
+	err := d.Client.GetContext(
 		ctx,
-		`SELECT id, slug, body, author
+		&cmtRow,
+		`SELECT `+commentColumns+`
 		FROM comments
-		WHERE id = $1`,
-		uuid,
+		WHERE id = $1 AND deleted_at IS NULL`,
+		id,
 	)
-	err = row.Scan(&cmtRow.ID, &cmtRow.Slug, &cmtRow.Body, &cmtRow.Author)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return comment.Comment{}, fmt.Errorf("error fetching the comment by uuid: %w", comment.ErrCommentNotFound)
+		}
 		return comment.Comment{}, fmt.Errorf("error fetching the comment by uuid: %w", err)
 	}
 
 	return convertCommentRowToComment(cmtRow), nil
 }
 
+// PostComment inserts a new comment along with its first revision.
+// The ID and timestamps are always generated here, whatever the caller put in the comment.
 func (d *Database) PostComment(ctx context.Context, cmt comment.Comment) (comment.Comment, error) {
-	cmt.ID = uuid.NewV4().String()
 	postRow := CommentRow{
-		ID:     cmt.ID,
-		Slug:   sql.NullString{String: cmt.Slug, Valid: true},
-		Author: sql.NullString{String: cmt.Author, Valid: true},
-		Body:   sql.NullString{String: cmt.Body, Valid: true},
+		ID:      uuid.NewV4().String(),
+		Slug:    sql.NullString{String: cmt.Slug, Valid: true},
+		Author:  sql.NullString{String: cmt.Author, Valid: true},
+		OwnerID: cmt.OwnerID,
+		Body:    sql.NullString{String: cmt.Body, Valid: true},
+		// Top-level comments store a NULL parent rather than an empty string
+		ParentID: sql.NullString{String: cmt.ParentID, Valid: cmt.ParentID != ""},
 	}
-	rows, err := d.Client.NamedQueryContext(
-		ctx,
-		`INSERT INTO comments
-		(id, slug, author, body)
-		VALUES
-		(:id, :slug, :author, :body)`,
-		postRow,
-	)
+
+	var insertedRow CommentRow
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		stmt, err := tx.PrepareNamedContext(
+			ctx,
+			`INSERT INTO comments
+			(id, slug, author, owner_id, body, parent_id, created_at, updated_at)
+			VALUES
+			(:id, :slug, :author, :owner_id, :body, :parent_id, now(), now())
+			RETURNING `+commentColumns,
+		)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		if err := stmt.GetContext(ctx, &insertedRow, postRow); err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, insertedRow)
+	})
 	if err != nil {
 		return comment.Comment{}, fmt.Errorf("failed to insert comment: %w", err)
 	}
-	if err := rows.Close(); err != nil {
-		return comment.Comment{}, fmt.Errorf("failed to close rows: %w", err)
-	}
 
-	return cmt, nil
+	return convertCommentRowToComment(insertedRow), nil
 }
 
-func (d *Database) DeleteComment(ctx context.Context, id string) error {
-	_, err := d.Client.ExecContext(
+// DeleteComment soft deletes a comment by stamping its deleted_at column.
+// A non-zero version must match the comment's current version.
+func (d *Database) DeleteComment(ctx context.Context, id string, version int) error {
+	if !isValidID(id) {
+		return fmt.Errorf("failed to delete comment from database: %w", comment.ErrCommentNotFound)
+	}
+	res, err := d.Client.ExecContext(
 		ctx,
-		`DELETE FROM comments WHERE id = $1`,
+		`UPDATE comments SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)`,
 		id,
+		version,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to delete comment from database: %w", err)
 	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete comment from database: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("failed to delete comment from database: %w", missingOrChanged(ctx, d.Client, id, version))
+	}
 	return nil
 }
 
+// missingOrChanged explains why a write to a comment matched no rows: either the comment
+// doesn't exist or, when the write asked for a version, the comment is at another one
+func missingOrChanged(ctx context.Context, q sqlx.QueryerContext, id string, version int) error {
+	if version == 0 {
+		return comment.ErrCommentNotFound
+	}
+	var exists bool
+	if err := sqlx.GetContext(
+		ctx,
+		q,
+		&exists,
+		`SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1 AND deleted_at IS NULL)`,
+		id,
+	); err != nil {
+		return err
+	}
+	if exists {
+		return comment.ErrVersionMismatch
+	}
+	return comment.ErrCommentNotFound
+}
+
+// RestoreComment clears the deleted_at column of a soft deleted comment
+func (d *Database) RestoreComment(ctx context.Context, id string) (comment.Comment, error) {
+	if !isValidID(id) {
+		return comment.Comment{}, fmt.Errorf("failed to restore comment: %w", comment.ErrCommentNotFound)
+	}
+	var cmtRow CommentRow
+	err := d.Client.GetContext(
+		ctx,
+		&cmtRow,
+		`UPDATE comments SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING `+commentColumns,
+		id,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return comment.Comment{}, fmt.Errorf("failed to restore comment: %w", comment.ErrCommentNotFound)
+		}
+		return comment.Comment{}, fmt.Errorf("failed to restore comment: %w", err)
+	}
+
+	return convertCommentRowToComment(cmtRow), nil
+}
+
+// PurgeComments permanently removes comments that were soft deleted before the given time.
+// The replies beneath a purged comment, which have been hidden since it was deleted, go with it.
+func (d *Database) PurgeComments(ctx context.Context, before time.Time) (int64, error) {
+	res, err := d.Client.ExecContext(
+		ctx,
+		`WITH RECURSIVE purged AS (
+			SELECT id FROM comments WHERE deleted_at IS NOT NULL AND deleted_at < $1
+			UNION
+			SELECT comments.id FROM comments JOIN purged ON comments.parent_id = purged.id
+		)
+		DELETE FROM comments WHERE id IN (SELECT id FROM purged)`,
+		before,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted comments: %w", err)
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted comments: %w", err)
+	}
+	return purged, nil
+}
+
+// UpdateComment changes the fields of a comment set in the update and bumps its version and updated_at,
+// leaving every other column as it is. A non-zero version must match the comment's current version.
+// The new content is recorded as a revision in the same transaction.
 func (d *Database) UpdateComment(
 	ctx context.Context,
 	id string,
-	cmt comment.Comment,
+	version int,
+	update comment.Update,
 ) (comment.Comment, error) {
-	cmtRow := CommentRow{
-		ID:     id,
-		Slug:   sql.NullString{String: cmt.Slug, Valid: true},
-		Author: sql.NullString{String: cmt.Author, Valid: true},
-		Body:   sql.NullString{String: cmt.Body, Valid: true},
+	if !isValidID(id) {
+		return comment.Comment{}, fmt.Errorf("failed to update comment: %w", comment.ErrCommentNotFound)
 	}
 
-	rows, err := d.Client.NamedQueryContext(
-		ctx,
-		`UPDATE comments SET
-		slug = :slug,
-		author = :author,
-		body = :body
-		WHERE id = :id`,
-		cmtRow,
-	)
+	var updatedRow CommentRow
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		// Fields left out of the update are passed as NULL and keep their current value
+		err := tx.GetContext(
+			ctx,
+			&updatedRow,
+			`UPDATE comments SET
+			slug = COALESCE($2, slug),
+			body = COALESCE($3, body),
+			version = version + 1,
+			updated_at = now()
+			WHERE id = $1 AND deleted_at IS NULL AND ($4 = 0 OR version = $4)
+			RETURNING `+commentColumns,
+			id,
+			update.Slug,
+			update.Body,
+			version,
+		)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return missingOrChanged(ctx, tx, id, version)
+			}
+			return err
+		}
+		return insertRevision(ctx, tx, updatedRow)
+	})
 	if err != nil {
 		return comment.Comment{}, fmt.Errorf("failed to update comment: %w", err)
 	}
-	if err := rows.Close(); err != nil {
-		return comment.Comment{}, fmt.Errorf("failed to close rows: %w", err)
+
+	return convertCommentRowToComment(updatedRow), nil
+}
+
+// ListComments returns up to limit comments for a slug in creation order, starting after the cursor
+func (d *Database) ListComments(
+	ctx context.Context,
+	slug string,
+	after comment.Cursor,
+	limit int,
+) ([]comment.Comment, error) {
+	query := `SELECT ` + commentColumns + `
+		FROM comments
+		WHERE slug = $1 AND deleted_at IS NULL`
+	args := []interface{}{slug}
+	if !after.IsZero() {
+		query += ` AND (created_at, id) > ($2, $3)`
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY created_at ASC, id ASC LIMIT $%d`, len(args))
+
+	var cmtRows []CommentRow
+	if err := d.Client.SelectContext(ctx, &cmtRows, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list comments: %w", err)
 	}
 
-	return convertCommentRowToComment(cmtRow), nil
+	return convertCommentRowsToComments(cmtRows), nil
+}
+
+// ListCommentTree loads a slug's comment tree with a single recursive query.
+// Each level is in creation order and limited per parent using a lateral join.
+// Deleted comments are left out along with all of their replies, as are replies moved to another slug.
+func (d *Database) ListCommentTree(ctx context.Context, q comment.TreeQuery) ([]comment.Comment, error) {
+	// A parent that can't exist has no replies
+	if q.ParentID != "" && !isValidID(q.ParentID) {
+		return nil, nil
+	}
+
+	args := []interface{}{q.Slug, q.Limit, q.Depth}
+	root := `parent_id IS NULL`
+	if q.ParentID != "" {
+		args = append(args, q.ParentID)
+		root = fmt.Sprintf(`parent_id = $%d`, len(args))
+	}
+	if !q.After.IsZero() {
+		args = append(args, q.After.CreatedAt, q.After.ID)
+		root += fmt.Sprintf(` AND (created_at, id) > ($%d, $%d)`, len(args)-1, len(args))
+	}
+
+	var cmtRows []CommentRow
+	err := d.Client.SelectContext(
+		ctx,
+		&cmtRows,
+		`WITH RECURSIVE tree AS (
+			(SELECT `+commentColumns+`, 1 AS depth
+			FROM comments
+			WHERE slug = $1 AND deleted_at IS NULL AND `+root+`
+			ORDER BY created_at ASC, id ASC
+			LIMIT $2)
+			UNION ALL
+			SELECT reply.*, tree.depth + 1
+			FROM tree
+			CROSS JOIN LATERAL (
+				SELECT `+commentColumns+`
+				FROM comments
+				WHERE slug = $1 AND parent_id = tree.id AND deleted_at IS NULL
+				ORDER BY created_at ASC, id ASC
+				LIMIT $2
+			) reply
+			WHERE tree.depth < $3
+		)
+		SELECT `+commentColumns+`
+		FROM tree
+		ORDER BY depth ASC, created_at ASC, id ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comment tree: %w", err)
+	}
+
+	return convertCommentRowsToComments(cmtRows), nil
 }
@@ -8,10 +8,21 @@ import (
 	"testing"
 
 	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment/commenttest"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// testConfig reads the database settings from the same environment variables as the server.
+func testConfig(t *testing.T) config.Database {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("could not load the database config: %v", err)
+	}
+	return cfg.Database
+}
+
 // TestCommentDatabase is a test function for testing comment-related database operations.
 func TestCommentDatabase(t *testing.T) {
 	// t.Run represents a sub-test within TestCommentDatabase.
@@ -19,7 +30,7 @@ func TestCommentDatabase(t *testing.T) {
 	// Sub-test to test creating a comment.
 	t.Run("test create comment", func(t *testing.T) {
 		// Create a new database instance.
-		db, err := NewDatabase()
+		db, err := NewDatabase(testConfig(t))
 		// Assert that there is no error in creating the database.
 		assert.NoError(t, err)
 
@@ -43,7 +54,7 @@ func TestCommentDatabase(t *testing.T) {
 	// Sub-test to test deleting a comment.
 	t.Run("test delete comment", func(t *testing.T) {
 		// Create a new database instance.
-		db, err := NewDatabase()
+		db, err := NewDatabase(testConfig(t))
 		assert.NoError(t, err)
 
 		// Post a new comment to the database and get the created comment.
@@ -55,7 +66,7 @@ func TestCommentDatabase(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Delete the comment from the database using its ID.
-		err = db.DeleteComment(context.Background(), cmt.ID)
+		err = db.DeleteComment(context.Background(), cmt.ID, 0)
 		assert.NoError(t, err)
 
 		// Attempt to retrieve the deleted comment from the database using its ID.
@@ -64,3 +75,15 @@ func TestCommentDatabase(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+// TestCommentDatabaseConformance runs the shared comment.Store suite against Postgres.
+func TestCommentDatabaseConformance(t *testing.T) {
+	db, err := NewDatabase(testConfig(t))
+	if err != nil {
+		t.Fatalf("could not connect to the database: %v", err)
+	}
+
+	commenttest.RunStoreTests(t, func(t *testing.T) comment.Store {
+		return db
+	})
+}
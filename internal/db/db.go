@@ -3,10 +3,12 @@ package db
 import (
 	"context"
 	"fmt"
-	"os"
+	"time"
 
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
 )
 
 // Database is a struct that represents a database connection.
@@ -15,16 +17,16 @@ type Database struct {
 }
 
 // NewDatabase creates a new Database instance and establishes a connection to the database.
-func NewDatabase() (*Database, error) {
-	// Create a connection string using environment variables.
+func NewDatabase(cfg config.Database) (*Database, error) {
+	// Create a connection string from the database configuration.
 	connectionString := fmt.Sprintf(
-		"host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USERNAME"),
-		os.Getenv("DB_TABLE"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("SSL_MODE"),
+		"host=%s port=%d user=%s dbname=%s password=%s sslmode=%s",
+		cfg.Host,
+		cfg.Port,
+		cfg.Username,
+		cfg.Name,
+		cfg.Password,
+		cfg.SSLMode,
 	)
 	// Connect to the PostgreSQL database using the sqlx package.
 	dbConn, err := sqlx.Connect("postgres", connectionString)
@@ -37,7 +39,88 @@ func NewDatabase() (*Database, error) {
 	}, nil
 }
 
+// maxConnectBackoff caps the delay between connection attempts
+const maxConnectBackoff = 10 * time.Second
+
+// Connect connects to the database like NewDatabase, retrying with exponential backoff for up
+// to cfg.ConnectTimeout so that the server can start before the database is accepting connections.
+func Connect(ctx context.Context, cfg config.Database) (*Database, error) {
+	var db *Database
+	err := retry(ctx, cfg.ConnectTimeout, cfg.ConnectBackoff, func() error {
+		var err error
+		db, err = NewDatabase(cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// retry calls attempt until it succeeds or the timeout is up, doubling the wait after every
+// failure up to maxConnectBackoff. The last error is returned if every attempt failed.
+func retry(ctx context.Context, timeout, backoff time.Duration, attempt func() error) error {
+	deadline := time.Now().Add(timeout)
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		// The last wait is cut short so that one final attempt is made at the deadline
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return fmt.Errorf("gave up after %d attempts: %w", n, err)
+		}
+		if wait > backoff {
+			wait = backoff
+		}
+		log.WithError(err).WithFields(log.Fields{
+			"attempt":  n,
+			"retry_in": wait.String(),
+		}).Warn("could not connect to the database")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up after %d attempts: %w", n, err)
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// Close closes the connection pool, waiting for running queries to finish
+func (d *Database) Close() error {
+	return d.Client.Close()
+}
+
 // Ping sends a ping request to the database server to check if the connection is alive.
 func (d *Database) Ping(ctx context.Context) error {
 	return d.Client.DB.PingContext(ctx)
 }
+
+// inTx runs fn inside a transaction, committing when it succeeds and rolling back when it fails.
+func (d *Database) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := d.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
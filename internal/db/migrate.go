@@ -4,30 +4,42 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/JonathanBaggott/go-rest-api-course-v2/migrations"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
 )
 
-// MigrateDB performs the database migration using the golang-migrate library.
-func (d *Database) MigrateDB() error {
-	fmt.Println("migrating our database")
-
+// Migrator returns a golang-migrate instance for the migrations embedded in the binary.
+// Closing it closes the database connection too, so the server never does.
+func (d *Database) Migrator() (*migrate.Migrate, error) {
 	// Create a PostgreSQL driver instance.
 	driver, err := postgres.WithInstance(d.Client.DB, &postgres.Config{})
 	if err != nil {
-		return fmt.Errorf("could not create the postgres driver: %w", err)
+		return nil, fmt.Errorf("could not create the postgres driver: %w", err)
+	}
+
+	src, err := migrations.Source(migrations.Postgres())
+	if err != nil {
+		return nil, err
 	}
 
 	// Create a new migration instance with the PostgreSQL driver.
-	m, err := migrate.NewWithDatabaseInstance(
-		"file:///migrations",
-		"postgres",
-		driver,
-	)
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the migrator: %w", err)
+	}
+	return m, nil
+}
+
+// MigrateDB performs the database migration using the golang-migrate library.
+// The schema version reached is logged whether or not the migration succeeds.
+func (d *Database) MigrateDB() error {
+	log.Info("migrating our database")
+
+	m, err := d.Migrator()
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 
@@ -35,10 +47,11 @@ func (d *Database) MigrateDB() error {
 	if err := m.Up(); err != nil {
 		// If there are no pending migrations, migrate.ErrNoChange is returned, and the migration process continues.
 		if !errors.Is(err, migrate.ErrNoChange) {
+			log.WithFields(migrations.VersionFields(m)).Error("migrating the database failed")
 			return fmt.Errorf("could not run up migrations: %w", err)
 		}
 	}
-	fmt.Println("successfully migrated the database")
+	log.WithFields(migrations.VersionFields(m)).Info("successfully migrated the database")
 
 	// nil is returned to indicate that the migration was successful.
 	return nil
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	errDown := errors.New("connection refused")
+
+	t.Run("retries until the attempt succeeds", func(t *testing.T) {
+		attempts := 0
+		err := retry(context.Background(), time.Second, time.Millisecond, func() error {
+			attempts++
+			if attempts < 3 {
+				return errDown
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		attempts := 0
+		start := time.Now()
+		err := retry(context.Background(), 50*time.Millisecond, 10*time.Millisecond, func() error {
+			attempts++
+			return errDown
+		})
+		assert.ErrorIs(t, err, errDown)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		// Waits of 10ms and 20ms, then a final wait cut short at the deadline
+		assert.LessOrEqual(t, attempts, 4)
+		assert.GreaterOrEqual(t, attempts, 3)
+	})
+
+	t.Run("a zero timeout means a single attempt", func(t *testing.T) {
+		attempts := 0
+		err := retry(context.Background(), 0, time.Millisecond, func() error {
+			attempts++
+			return errDown
+		})
+		assert.ErrorIs(t, err, errDown)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts := 0
+		err := retry(ctx, time.Minute, time.Second, func() error {
+			attempts++
+			return errDown
+		})
+		assert.ErrorIs(t, err, errDown)
+		assert.Equal(t, 1, attempts)
+	})
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+)
+
+// headlineOptions tells ts_headline how to mark the matching words of a snippet
+var headlineOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s"`, comment.HighlightStart, comment.HighlightStop)
+
+// escapedBody escapes a comment's body just as html.EscapeString does, so the snippets
+// ts_headline builds from it hold no markup other than the highlighting
+const escapedBody = `replace(replace(replace(replace(replace(coalesce(body, ''),
+	'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+
+// SearchRow models a comment matching a search along with its rank and snippet
+type SearchRow struct {
+	CommentRow
+	Rank    float64
+	Snippet string
+}
+
+// SearchComments runs a full-text search over the bodies of live comments using the indexed search_vector column.
+// The search text is parsed with websearch_to_tsquery, so it understands "quoted phrases", OR and -exclusions.
+// Snippets are only built for the page of results returned, as ts_headline has to re-parse each body.
+// They're built from the HTML-escaped body, so markup in a comment is shown rather than run.
+func (d *Database) SearchComments(ctx context.Context, q comment.SearchQuery) ([]comment.SearchResult, error) {
+	args := []interface{}{q.Text}
+	where := `deleted_at IS NULL AND search_vector @@ websearch_to_tsquery('english', $1)`
+	if q.Slug != "" {
+		args = append(args, q.Slug)
+		where += fmt.Sprintf(` AND slug = $%d`, len(args))
+	}
+	if q.Author != "" {
+		args = append(args, q.Author)
+		where += fmt.Sprintf(` AND author = $%d`, len(args))
+	}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		where += fmt.Sprintf(` AND created_at >= $%d`, len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		where += fmt.Sprintf(` AND created_at < $%d`, len(args))
+	}
+
+	page := ``
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		page += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	if q.Offset > 0 {
+		args = append(args, q.Offset)
+		page += fmt.Sprintf(` OFFSET $%d`, len(args))
+	}
+	args = append(args, headlineOptions)
+
+	var rows []SearchRow
+	err := d.Client.SelectContext(
+		ctx,
+		&rows,
+		`SELECT `+commentColumns+`, rank,
+			ts_headline('english', `+escapedBody+`, websearch_to_tsquery('english', $1), $`+fmt.Sprint(len(args))+`) AS snippet
+		FROM (
+			SELECT `+commentColumns+`, ts_rank(search_vector, websearch_to_tsquery('english', $1)) AS rank
+			FROM comments
+			WHERE `+where+`
+			ORDER BY rank DESC, created_at DESC, id ASC`+page+`
+		) matches
+		ORDER BY rank DESC, created_at DESC, id ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search comments: %w", err)
+	}
+
+	results := make([]comment.SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, comment.SearchResult{
+			Comment: convertCommentRowToComment(row.CommentRow),
+			Rank:    row.Rank,
+			Snippet: row.Snippet,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,163 @@
+// Package health reports whether the server is alive and whether it is ready to serve traffic.
+// Dependencies plug in by registering a Checker; every check runs on each request to the
+// health endpoints, concurrently and with a timeout.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses reported for the server as a whole and for each check
+const (
+	StatusOK           = "ok"
+	StatusFail         = "fail"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Checker - a dependency whose health is reported
+type Checker interface {
+	// Name identifies the dependency in reports
+	Name() string
+	// Check returns an error when the dependency can't be used
+	Check(ctx context.Context) error
+}
+
+// CheckFunc turns a function into a Checker with the given name
+func CheckFunc(name string, check func(ctx context.Context) error) Checker {
+	return checkFunc{name: name, check: check}
+}
+
+type checkFunc struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (c checkFunc) Name() string                    { return c.name }
+func (c checkFunc) Check(ctx context.Context) error { return c.check(ctx) }
+
+// Result is the outcome of a single check
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check, along with the overall status
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// OK reports whether every check passed
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Health - the checks behind the liveness and readiness endpoints
+type Health struct {
+	timeout time.Duration
+
+	mu        sync.RWMutex
+	liveness  []Checker
+	readiness []Checker
+	draining  atomic.Bool
+}
+
+// New returns a Health with no checks, whose checks are each given timeout to finish
+func New(timeout time.Duration) *Health {
+	return &Health{timeout: timeout}
+}
+
+// AddLivenessCheck registers a check that, when failing, means the server should be restarted.
+// Liveness checks should only cover the process itself, never its dependencies.
+func (h *Health) AddLivenessCheck(c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, c)
+}
+
+// AddReadinessCheck registers a dependency the server can't serve traffic without
+func (h *Health) AddReadinessCheck(c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, c)
+}
+
+// Drain marks the server as shutting down, so readiness fails from now on and
+// load balancers stop sending it new requests
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Live runs the liveness checks
+func (h *Health) Live(ctx context.Context) Report {
+	h.mu.RLock()
+	checks := h.liveness
+	h.mu.RUnlock()
+	return h.run(ctx, checks)
+}
+
+// Ready runs the readiness checks. Once Drain has been called it fails without running them.
+func (h *Health) Ready(ctx context.Context) Report {
+	if h.draining.Load() {
+		return Report{Status: StatusShuttingDown, Checks: map[string]Result{}}
+	}
+	h.mu.RLock()
+	checks := h.readiness
+	h.mu.RUnlock()
+	return h.run(ctx, checks)
+}
+
+// run runs checks concurrently, each with its own timeout
+func (h *Health) run(ctx context.Context, checks []Checker) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Checker) {
+			defer wg.Done()
+			result := h.check(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.Name()] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+// check runs a single check, timing it. A check that ignores its context still
+// fails once the timeout is up rather than holding up the whole report.
+func (h *Health) check(ctx context.Context, c Checker) Result {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ok(ctx context.Context) error { return nil }
+
+func TestReadyReportsEveryCheck(t *testing.T) {
+	h := New(time.Second)
+	h.AddReadinessCheck(CheckFunc("postgres", ok))
+	h.AddReadinessCheck(CheckFunc("cache", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}))
+
+	report := h.Ready(context.Background())
+	assert.False(t, report.OK())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["postgres"].Status)
+	assert.Equal(t, StatusFail, report.Checks["cache"].Status)
+	assert.Equal(t, "connection refused", report.Checks["cache"].Error)
+
+	// Liveness doesn't depend on the readiness checks
+	assert.True(t, h.Live(context.Background()).OK())
+}
+
+func TestChecksTimeOut(t *testing.T) {
+	h := New(20 * time.Millisecond)
+	// This check ignores its context, which must not hold up the report
+	block := make(chan struct{})
+	defer close(block)
+	h.AddReadinessCheck(CheckFunc("stuck", func(ctx context.Context) error {
+		<-block
+		return nil
+	}))
+
+	start := time.Now()
+	report := h.Ready(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, StatusFail, report.Checks["stuck"].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["stuck"].Error)
+}
+
+func TestDrain(t *testing.T) {
+	h := New(time.Second)
+	h.AddReadinessCheck(CheckFunc("postgres", ok))
+	assert.True(t, h.Ready(context.Background()).OK())
+
+	h.Drain()
+	report := h.Ready(context.Background())
+	assert.False(t, report.OK())
+	assert.Equal(t, StatusShuttingDown, report.Status)
+	// The server is still alive while it drains
+	assert.True(t, h.Live(context.Background()).OK())
+}
@@ -0,0 +1,60 @@
+// Package logging configures the structured logger and carries request scoped loggers in contexts.
+// Code that handles a request logs through FromContext, so every line it writes carries the
+// request ID and trace ID of the request it was written for.
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup applies the configured level and format to the standard logrus logger
+func Setup(cfg config.Logging) error {
+	level, err := log.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+
+	switch cfg.Format {
+	case config.FormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	case config.FormatText:
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	return nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given logger
+func NewContext(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored by NewContext. Without one it returns the standard
+// logger, tagged with the trace ID of the span in ctx if there is one.
+func FromContext(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(contextKey{}).(*log.Entry); ok {
+		return logger
+	}
+	return WithTrace(ctx, log.NewEntry(log.StandardLogger()))
+}
+
+// WithTrace adds the trace and span IDs of the span in ctx, if any, to a logger
+func WithTrace(ctx context.Context, logger *log.Entry) *log.Entry {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return logger
+	}
+	return logger.WithFields(log.Fields{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	})
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// captureLogs sends the standard logger's output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	logger := log.StandardLogger()
+	out, formatter, level := logger.Out, logger.Formatter, logger.Level
+	t.Cleanup(func() {
+		logger.SetOutput(out)
+		logger.SetFormatter(formatter)
+		logger.SetLevel(level)
+	})
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	return &buf
+}
+
+func TestSetup(t *testing.T) {
+	buf := captureLogs(t)
+	require.NoError(t, Setup(config.Logging{Level: "warn", Format: config.FormatJSON}))
+
+	log.Info("hidden")
+	assert.Empty(t, buf.String())
+
+	log.WithField("answer", 42).Warn("shown")
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "shown", line["msg"])
+	assert.Equal(t, "warning", line["level"])
+	assert.Equal(t, float64(42), line["answer"])
+
+	assert.Error(t, Setup(config.Logging{Level: "loud", Format: config.FormatText}))
+	assert.Error(t, Setup(config.Logging{Level: "info", Format: "xml"}))
+}
+
+func TestFromContext(t *testing.T) {
+	captureLogs(t)
+
+	t.Run("uses the logger in the context", func(t *testing.T) {
+		logger := log.WithField("request_id", "abc")
+		ctx := NewContext(context.Background(), logger)
+		assert.Same(t, logger, FromContext(ctx))
+	})
+
+	t.Run("falls back to the standard logger", func(t *testing.T) {
+		entry := FromContext(context.Background())
+		assert.Same(t, log.StandardLogger(), entry.Logger)
+		assert.Empty(t, entry.Data)
+	})
+
+	t.Run("tags the fallback with the trace", func(t *testing.T) {
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  spanID,
+		}))
+
+		entry := FromContext(ctx)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entry.Data["trace_id"])
+		assert.Equal(t, "00f067aa0ba902b7", entry.Data["span_id"])
+	})
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	uuid "github.com/satori/go.uuid"
+)
+
+// apiKeyRecord is a stored API key along with the hash of its secret
+type apiKeyRecord struct {
+	key  auth.APIKey
+	hash string
+}
+
+// copyAPIKey returns a key that shares no memory with the stored one
+func copyAPIKey(key auth.APIKey) auth.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	for _, t := range []**time.Time{&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt} {
+		if *t != nil {
+			v := **t
+			*t = &v
+		}
+	}
+	return key
+}
+
+// CreateAPIKey stores a new API key, generating its ID and creation time
+func (s *Store) CreateAPIKey(ctx context.Context, key auth.APIKey, hash string) (auth.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key = copyAPIKey(key)
+	key.ID = uuid.NewV4().String()
+	key.CreatedAt = s.now()
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+	s.apiKeys[key.ID] = &apiKeyRecord{key: key, hash: hash}
+	return copyAPIKey(key), nil
+}
+
+// GetAPIKeyByHash returns the key whose secret hashes to hash
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (auth.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rec := range s.apiKeys {
+		if rec.hash == hash {
+			return copyAPIKey(rec.key), nil
+		}
+	}
+	return auth.APIKey{}, fmt.Errorf("failed to fetch api key: %w", auth.ErrAPIKeyNotFound)
+}
+
+// ListAPIKeys returns every key in creation order
+func (s *Store) ListAPIKeys(ctx context.Context) ([]auth.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]auth.APIKey, 0, len(s.apiKeys))
+	for _, rec := range s.apiKeys {
+		keys = append(keys, copyAPIKey(rec.key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey marks a key that hasn't been revoked yet as revoked
+func (s *Store) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.apiKeys[id]
+	if !ok || rec.key.RevokedAt != nil {
+		return fmt.Errorf("failed to revoke api key: %w", auth.ErrAPIKeyNotFound)
+	}
+	at = at.UTC().Truncate(time.Microsecond)
+	rec.key.RevokedAt = &at
+	return nil
+}
+
+// TouchAPIKey records when a key was last used
+func (s *Store) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.apiKeys[id]
+	if !ok {
+		return fmt.Errorf("failed to record api key use: %w", auth.ErrAPIKeyNotFound)
+	}
+	at = at.UTC().Truncate(time.Microsecond)
+	rec.key.LastUsedAt = &at
+	return nil
+}
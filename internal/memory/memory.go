@@ -0,0 +1,297 @@
+// Package memory provides a comment.Store that keeps everything in process memory.
+// It behaves like the Postgres store in package db, which makes it useful for tests
+// and for running the API locally without a database; nothing survives a restart.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	uuid "github.com/satori/go.uuid"
+)
+
+// record is a stored comment along with the bookkeeping that isn't part of comment.Comment
+type record struct {
+	cmt       comment.Comment
+	deletedAt *time.Time
+	revisions []comment.Revision
+}
+
+// Store is a concurrency-safe, in-memory implementation of comment.Store and auth.KeyStore
+type Store struct {
+	mu       sync.RWMutex
+	comments map[string]*record
+	apiKeys  map[string]*apiKeyRecord
+	lastNow  time.Time
+}
+
+// NewStore returns an empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		comments: make(map[string]*record),
+		apiKeys:  make(map[string]*apiKeyRecord),
+	}
+}
+
+// now returns the current time at the microsecond precision Postgres stores.
+// Successive calls always move forward, so writes are ordered just as separate transactions would be.
+// It must be called with the write lock held.
+func (s *Store) now() time.Time {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if !now.After(s.lastNow) {
+		now = s.lastNow.Add(time.Microsecond)
+	}
+	s.lastNow = now
+	return now
+}
+
+// live returns the record for a comment that exists and hasn't been deleted.
+// It must be called with the lock held.
+func (s *Store) live(id string) (*record, bool) {
+	rec, ok := s.comments[id]
+	if !ok || rec.deletedAt != nil {
+		return nil, false
+	}
+	return rec, true
+}
+
+// addRevision records the current content of a comment as its next revision.
+// It must be called with the write lock held.
+func addRevision(rec *record) {
+	rec.revisions = append(rec.revisions, comment.Revision{
+		CommentID: rec.cmt.ID,
+		Revision:  len(rec.revisions) + 1,
+		Slug:      rec.cmt.Slug,
+		Author:    rec.cmt.Author,
+		Body:      rec.cmt.Body,
+		CreatedAt: rec.cmt.UpdatedAt,
+	})
+}
+
+// GetComment returns a comment that hasn't been deleted
+func (s *Store) GetComment(ctx context.Context, id string) (comment.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.live(id)
+	if !ok {
+		return comment.Comment{}, fmt.Errorf("error fetching the comment by uuid: %w", comment.ErrCommentNotFound)
+	}
+	return rec.cmt, nil
+}
+
+// PostComment stores a new comment along with its first revision.
+// The ID and timestamps are always generated here, whatever the caller put in the comment.
+func (s *Store) PostComment(ctx context.Context, cmt comment.Comment) (comment.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	cmt.ID = uuid.NewV4().String()
+	cmt.Version = 1
+	cmt.CreatedAt = now
+	cmt.UpdatedAt = now
+
+	rec := &record{cmt: cmt}
+	addRevision(rec)
+	s.comments[cmt.ID] = rec
+	return cmt, nil
+}
+
+// UpdateComment changes the fields of a comment set in the update, bumps its version and records a revision
+func (s *Store) UpdateComment(ctx context.Context, id string, version int, update comment.Update) (comment.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.live(id)
+	if !ok {
+		return comment.Comment{}, fmt.Errorf("failed to update comment: %w", comment.ErrCommentNotFound)
+	}
+	if version != 0 && rec.cmt.Version != version {
+		return comment.Comment{}, fmt.Errorf("failed to update comment: %w", comment.ErrVersionMismatch)
+	}
+	if update.Slug != nil {
+		rec.cmt.Slug = *update.Slug
+	}
+	if update.Body != nil {
+		rec.cmt.Body = *update.Body
+	}
+	rec.cmt.Version++
+	rec.cmt.UpdatedAt = s.now()
+	addRevision(rec)
+	return rec.cmt, nil
+}
+
+// DeleteComment soft deletes a comment
+func (s *Store) DeleteComment(ctx context.Context, id string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.live(id)
+	if !ok {
+		return fmt.Errorf("failed to delete comment: %w", comment.ErrCommentNotFound)
+	}
+	if version != 0 && rec.cmt.Version != version {
+		return fmt.Errorf("failed to delete comment: %w", comment.ErrVersionMismatch)
+	}
+	now := s.now()
+	rec.deletedAt = &now
+	return nil
+}
+
+// RestoreComment brings back a soft deleted comment
+func (s *Store) RestoreComment(ctx context.Context, id string) (comment.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.comments[id]
+	if !ok || rec.deletedAt == nil {
+		return comment.Comment{}, fmt.Errorf("failed to restore comment: %w", comment.ErrCommentNotFound)
+	}
+	rec.deletedAt = nil
+	return rec.cmt, nil
+}
+
+// PurgeComments permanently removes comments that were soft deleted before the given time.
+// The replies beneath a purged comment, which have been hidden since it was deleted, go with it.
+func (s *Store) PurgeComments(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purge := make(map[string]bool)
+	for id, rec := range s.comments {
+		if rec.deletedAt != nil && rec.deletedAt.Before(before) {
+			purge[id] = true
+		}
+	}
+	// Keep sweeping in replies until a pass finds no more
+	for found := true; found; {
+		found = false
+		for id, rec := range s.comments {
+			if !purge[id] && purge[rec.cmt.ParentID] {
+				purge[id] = true
+				found = true
+			}
+		}
+	}
+
+	for id := range purge {
+		delete(s.comments, id)
+	}
+	return int64(len(purge)), nil
+}
+
+// ListRevisions returns every revision of a comment that hasn't been deleted, oldest first
+func (s *Store) ListRevisions(ctx context.Context, id string) ([]comment.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.live(id)
+	if !ok {
+		return nil, fmt.Errorf("failed to list comment revisions: %w", comment.ErrCommentNotFound)
+	}
+	revs := make([]comment.Revision, len(rec.revisions))
+	copy(revs, rec.revisions)
+	return revs, nil
+}
+
+// ListComments returns up to limit comments for a slug in creation order, starting after the cursor
+func (s *Store) ListComments(
+	ctx context.Context,
+	slug string,
+	after comment.Cursor,
+	limit int,
+) ([]comment.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.page(func(cmt comment.Comment) bool {
+		return cmt.Slug == slug
+	}, after, limit), nil
+}
+
+// ListCommentTree returns a slug's comment tree level by level, limiting the replies of each parent.
+// Deleted comments are left out along with all of their replies.
+func (s *Store) ListCommentTree(ctx context.Context, q comment.TreeQuery) ([]comment.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	level := s.page(func(cmt comment.Comment) bool {
+		return cmt.Slug == q.Slug && cmt.ParentID == q.ParentID
+	}, q.After, q.Limit)
+
+	var tree []comment.Comment
+	for depth := 1; len(level) > 0; depth++ {
+		tree = append(tree, level...)
+		if depth >= q.Depth {
+			break
+		}
+
+		var next []comment.Comment
+		for _, parent := range level {
+			parentID := parent.ID
+			next = append(next, s.page(func(cmt comment.Comment) bool {
+				return cmt.Slug == q.Slug && cmt.ParentID == parentID
+			}, comment.Cursor{}, q.Limit)...)
+		}
+		// Match the Postgres store, which orders each level by creation time across all parents
+		sortComments(next)
+		level = next
+	}
+
+	return tree, nil
+}
+
+// SearchComments searches the bodies of live comments with comment.MatchComments
+func (s *Store) SearchComments(ctx context.Context, q comment.SearchQuery) ([]comment.SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cmts := make([]comment.Comment, 0, len(s.comments))
+	for _, rec := range s.comments {
+		if rec.deletedAt == nil {
+			cmts = append(cmts, rec.cmt)
+		}
+	}
+	return comment.MatchComments(cmts, q), nil
+}
+
+// page returns up to limit live comments matching keep in creation order, starting after the cursor.
+// It must be called with the lock held.
+func (s *Store) page(keep func(comment.Comment) bool, after comment.Cursor, limit int) []comment.Comment {
+	var cmts []comment.Comment
+	for _, rec := range s.comments {
+		if rec.deletedAt != nil || !keep(rec.cmt) {
+			continue
+		}
+		if !after.IsZero() && !isAfter(rec.cmt, after) {
+			continue
+		}
+		cmts = append(cmts, rec.cmt)
+	}
+
+	sortComments(cmts)
+	if len(cmts) > limit {
+		cmts = cmts[:limit]
+	}
+	return cmts
+}
+
+// sortComments orders comments by creation time and then ID
+func sortComments(cmts []comment.Comment) {
+	sort.Slice(cmts, func(i, j int) bool {
+		return isAfter(cmts[j], comment.CursorAfter(cmts[i]))
+	})
+}
+
+// isAfter reports whether a comment sorts after the cursor, ordering by creation time and then ID
+func isAfter(cmt comment.Comment, cursor comment.Cursor) bool {
+	if !cmt.CreatedAt.Equal(cursor.CreatedAt) {
+		return cmt.CreatedAt.After(cursor.CreatedAt)
+	}
+	return cmt.ID > cursor.ID
+}
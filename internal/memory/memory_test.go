@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth/authtest"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment/commenttest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStore runs the shared comment.Store conformance suite against a fresh in-memory store.
+func TestStore(t *testing.T) {
+	commenttest.RunStoreTests(t, func(t *testing.T) comment.Store {
+		return NewStore()
+	})
+}
+
+// TestKeyStore runs the shared auth.KeyStore conformance suite against a fresh in-memory store.
+func TestKeyStore(t *testing.T) {
+	authtest.RunKeyStoreTests(t, func(t *testing.T) auth.KeyStore {
+		return NewStore()
+	})
+}
+
+// TestStoreConcurrentWrites checks the store stays consistent when written to from many goroutines.
+func TestStoreConcurrentWrites(t *testing.T) {
+	store := NewStore()
+	cmt, err := store.PostComment(context.Background(), comment.Comment{Slug: "slug", Author: "jono", Body: "body"})
+	assert.NoError(t, err)
+
+	body := "edit"
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.UpdateComment(context.Background(), cmt.ID, 0, comment.Update{Body: &body})
+			assert.NoError(t, err)
+			_, err = store.ListComments(context.Background(), "slug", comment.Cursor{}, 10)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// Every update was recorded as its own revision.
+	revs, err := store.ListRevisions(context.Background(), cmt.ID)
+	assert.NoError(t, err)
+	assert.Len(t, revs, 51)
+}
@@ -0,0 +1,112 @@
+// Package metrics collects the Prometheus metrics served on /metrics
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics - every metric the application exposes, kept in its own registry
+// so that tests can create as many as they like
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	httpInFlight    *prometheus.GaugeVec
+	storeDuration   *prometheus.HistogramVec
+	commentsPosted  prometheus.Counter
+	commentsUpdated prometheus.Counter
+	commentsDeleted prometheus.Counter
+}
+
+// New creates and registers the application's metrics along with the Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled, by route template, method and status code.",
+		}, []string{"route", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route template, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		httpInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests currently being handled, by route template and method.",
+		}, []string{"route", "method"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "store_query_duration_seconds",
+			Help:    "Time taken by store queries, by backend, store method and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"backend", "method", "outcome"}),
+		commentsPosted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "comments_posted_total",
+			Help: "Comments posted.",
+		}),
+		commentsUpdated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "comments_updated_total",
+			Help: "Comments updated.",
+		}),
+		commentsDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "comments_deleted_total",
+			Help: "Comments deleted.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.httpInFlight,
+		m.storeDuration,
+		m.commentsPosted,
+		m.commentsUpdated,
+		m.commentsDeleted,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// RegisterDB exposes the connection pool statistics of a database under the given name
+func (m *Metrics) RegisterDB(db *sql.DB, name string) error {
+	return m.Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// RequestStarted records a request to the given route template starting.
+// The returned function records it finishing with the given status code.
+// Both labels must come from a fixed set, as every new value is a new series.
+func (m *Metrics) RequestStarted(route, method string) func(code int) {
+	start := time.Now()
+	inFlight := m.httpInFlight.WithLabelValues(route, method)
+	inFlight.Inc()
+
+	return func(code int) {
+		inFlight.Dec()
+		status := strconv.Itoa(code)
+		m.httpRequests.WithLabelValues(route, method, status).Inc()
+		m.httpDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// CommentPosted counts a posted comment
+func (m *Metrics) CommentPosted() { m.commentsPosted.Inc() }
+
+// CommentUpdated counts an updated comment
+func (m *Metrics) CommentUpdated() { m.commentsUpdated.Inc() }
+
+// CommentDeleted counts a deleted comment
+func (m *Metrics) CommentDeleted() { m.commentsDeleted.Inc() }
@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth/authtest"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment/commenttest"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInstrumentedStore checks that wrapping a store doesn't change how it behaves
+func TestInstrumentedStore(t *testing.T) {
+	commenttest.RunStoreTests(t, func(t *testing.T) comment.Store {
+		return metrics.New().InstrumentStore(memory.NewStore(), "memory")
+	})
+	authtest.RunKeyStoreTests(t, func(t *testing.T) auth.KeyStore {
+		return metrics.New().InstrumentStore(memory.NewStore(), "memory")
+	})
+}
+
+func TestStoreLatency(t *testing.T) {
+	m := metrics.New()
+	store := m.InstrumentStore(memory.NewStore(), "memory")
+	ctx := context.Background()
+
+	cmt, err := store.PostComment(ctx, comment.Comment{Slug: "slug", Body: "body"})
+	require.NoError(t, err)
+	_, err = store.GetComment(ctx, cmt.ID)
+	require.NoError(t, err)
+	_, err = store.GetComment(ctx, "00000000-0000-0000-0000-000000000000")
+	require.Error(t, err)
+
+	// One series for each method and outcome seen
+	assert.Equal(t, 3, testutil.CollectAndCount(m.Registry, "store_query_duration_seconds"))
+	out := scrape(t, m)
+	assert.Contains(t, out, `store_query_duration_seconds_count{backend="memory",method="GetComment",outcome="error"} 1`)
+	assert.Contains(t, out, `store_query_duration_seconds_count{backend="memory",method="GetComment",outcome="ok"} 1`)
+	assert.Contains(t, out, `store_query_duration_seconds_count{backend="memory",method="PostComment",outcome="ok"} 1`)
+}
+
+func TestRequests(t *testing.T) {
+	m := metrics.New()
+
+	done := m.RequestStarted("/api/v1/comment/{id}", "GET")
+	assert.Contains(t, scrape(t, m), `http_requests_in_flight{method="GET",route="/api/v1/comment/{id}"} 1`)
+	done(404)
+
+	out := scrape(t, m)
+	assert.Contains(t, out, `http_requests_in_flight{method="GET",route="/api/v1/comment/{id}"} 0`)
+	assert.Contains(t, out, `http_requests_total{code="404",method="GET",route="/api/v1/comment/{id}"} 1`)
+	assert.Contains(t, out, `http_request_duration_seconds_count{code="404",method="GET",route="/api/v1/comment/{id}"} 1`)
+}
+
+func TestCommentCounters(t *testing.T) {
+	m := metrics.New()
+	m.CommentPosted()
+	m.CommentPosted()
+	m.CommentUpdated()
+	m.CommentDeleted()
+
+	out := scrape(t, m)
+	assert.Contains(t, out, "comments_posted_total 2")
+	assert.Contains(t, out, "comments_updated_total 1")
+	assert.Contains(t, out, "comments_deleted_total 1")
+}
+
+// scrape fetches the metrics in the text exposition format
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	return strings.TrimSpace(rec.Body.String())
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+)
+
+// Store - the methods of a store that are timed
+type Store interface {
+	comment.Store
+	auth.KeyStore
+}
+
+// instrumentedStore times every call made to the store it wraps
+type instrumentedStore struct {
+	next    Store
+	backend string
+	metrics *Metrics
+}
+
+// InstrumentStore wraps a store so that the latency of each of its methods is recorded,
+// labelled with the backend name and the method called
+func (m *Metrics) InstrumentStore(store Store, backend string) Store {
+	return &instrumentedStore{next: store, backend: backend, metrics: m}
+}
+
+// track starts timing a call to a store method. Deferring the returned function with a pointer
+// to the method's named error result records the call once it has returned.
+func (s *instrumentedStore) track(method string) func(err *error) {
+	start := time.Now()
+	return func(err *error) {
+		outcome := "ok"
+		if *err != nil {
+			outcome = "error"
+		}
+		s.metrics.storeDuration.WithLabelValues(s.backend, method, outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (s *instrumentedStore) GetComment(ctx context.Context, id string) (_ comment.Comment, err error) {
+	defer s.track("GetComment")(&err)
+	return s.next.GetComment(ctx, id)
+}
+
+func (s *instrumentedStore) PostComment(ctx context.Context, cmt comment.Comment) (_ comment.Comment, err error) {
+	defer s.track("PostComment")(&err)
+	return s.next.PostComment(ctx, cmt)
+}
+
+func (s *instrumentedStore) DeleteComment(ctx context.Context, id string, version int) (err error) {
+	defer s.track("DeleteComment")(&err)
+	return s.next.DeleteComment(ctx, id, version)
+}
+
+func (s *instrumentedStore) UpdateComment(ctx context.Context, id string, version int, update comment.Update) (_ comment.Comment, err error) {
+	defer s.track("UpdateComment")(&err)
+	return s.next.UpdateComment(ctx, id, version, update)
+}
+
+func (s *instrumentedStore) ListComments(ctx context.Context, slug string, after comment.Cursor, limit int) (_ []comment.Comment, err error) {
+	defer s.track("ListComments")(&err)
+	return s.next.ListComments(ctx, slug, after, limit)
+}
+
+func (s *instrumentedStore) ListCommentTree(ctx context.Context, q comment.TreeQuery) (_ []comment.Comment, err error) {
+	defer s.track("ListCommentTree")(&err)
+	return s.next.ListCommentTree(ctx, q)
+}
+
+func (s *instrumentedStore) RestoreComment(ctx context.Context, id string) (_ comment.Comment, err error) {
+	defer s.track("RestoreComment")(&err)
+	return s.next.RestoreComment(ctx, id)
+}
+
+func (s *instrumentedStore) PurgeComments(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer s.track("PurgeComments")(&err)
+	return s.next.PurgeComments(ctx, before)
+}
+
+func (s *instrumentedStore) ListRevisions(ctx context.Context, id string) (_ []comment.Revision, err error) {
+	defer s.track("ListRevisions")(&err)
+	return s.next.ListRevisions(ctx, id)
+}
+
+func (s *instrumentedStore) SearchComments(ctx context.Context, q comment.SearchQuery) (_ []comment.SearchResult, err error) {
+	defer s.track("SearchComments")(&err)
+	return s.next.SearchComments(ctx, q)
+}
+
+func (s *instrumentedStore) CreateAPIKey(ctx context.Context, key auth.APIKey, hash string) (_ auth.APIKey, err error) {
+	defer s.track("CreateAPIKey")(&err)
+	return s.next.CreateAPIKey(ctx, key, hash)
+}
+
+func (s *instrumentedStore) GetAPIKeyByHash(ctx context.Context, hash string) (_ auth.APIKey, err error) {
+	defer s.track("GetAPIKeyByHash")(&err)
+	return s.next.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *instrumentedStore) ListAPIKeys(ctx context.Context) (_ []auth.APIKey, err error) {
+	defer s.track("ListAPIKeys")(&err)
+	return s.next.ListAPIKeys(ctx)
+}
+
+func (s *instrumentedStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	defer s.track("RevokeAPIKey")(&err)
+	return s.next.RevokeAPIKey(ctx, id, at)
+}
+
+func (s *instrumentedStore) TouchAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	defer s.track("TouchAPIKey")(&err)
+	return s.next.TouchAPIKey(ctx, id, at)
+}
@@ -0,0 +1,15 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth/authtest"
+)
+
+// TestAPIKeyDatabaseConformance runs the shared auth.KeyStore suite against a fresh SQLite database.
+func TestAPIKeyDatabaseConformance(t *testing.T) {
+	authtest.RunKeyStoreTests(t, func(t *testing.T) auth.KeyStore {
+		return openTestDatabase(t)
+	})
+}
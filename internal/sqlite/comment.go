@@ -0,0 +1,340 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+// commentColumns lists the columns selected whenever a full comment is read
+const commentColumns = `id, slug, body, author, owner_id, parent_id, version, created_at, updated_at`
+
+// CommentRow models the columns within comments table in the database.
+// Timestamps are microseconds since the Unix epoch.
+type CommentRow struct {
+	ID        string
+	Slug      string
+	Body      string
+	Author    string
+	OwnerID   string         `db:"owner_id"`
+	ParentID  sql.NullString `db:"parent_id"`
+	Version   int            `db:"version"`
+	CreatedAt int64          `db:"created_at"`
+	UpdatedAt int64          `db:"updated_at"`
+}
+
+func convertCommentRowToComment(c CommentRow) comment.Comment {
+	return comment.Comment{
+		ID:        c.ID,
+		Slug:      c.Slug,
+		Author:    c.Author,
+		OwnerID:   c.OwnerID,
+		Body:      c.Body,
+		ParentID:  c.ParentID.String,
+		Version:   c.Version,
+		CreatedAt: fromMicros(c.CreatedAt),
+		UpdatedAt: fromMicros(c.UpdatedAt),
+	}
+}
+
+func convertCommentRowsToComments(rows []CommentRow) []comment.Comment {
+	cmts := make([]comment.Comment, 0, len(rows))
+	for _, row := range rows {
+		cmts = append(cmts, convertCommentRowToComment(row))
+	}
+	return cmts
+}
+
+func toMicros(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+func fromMicros(us int64) time.Time {
+	return time.UnixMicro(us).UTC()
+}
+
+// GetComment returns a comment that hasn't been deleted
+func (d *Database) GetComment(ctx context.Context, id string) (comment.Comment, error) {
+	var cmtRow CommentRow
+	err := d.Client.GetContext(
+		ctx,
+		&cmtRow,
+		`SELECT `+commentColumns+`
+		FROM comments
+		WHERE id = ? AND deleted_at IS NULL`,
+		id,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return comment.Comment{}, fmt.Errorf("error fetching the comment by uuid: %w", comment.ErrCommentNotFound)
+		}
+		return comment.Comment{}, fmt.Errorf("error fetching the comment by uuid: %w", err)
+	}
+
+	return convertCommentRowToComment(cmtRow), nil
+}
+
+// PostComment inserts a new comment along with its first revision.
+// The ID and timestamps are always generated here, whatever the caller put in the comment.
+func (d *Database) PostComment(ctx context.Context, cmt comment.Comment) (comment.Comment, error) {
+	now := toMicros(d.now())
+	postRow := CommentRow{
+		ID:        uuid.NewV4().String(),
+		Slug:      cmt.Slug,
+		Author:    cmt.Author,
+		OwnerID:   cmt.OwnerID,
+		Body:      cmt.Body,
+		ParentID:  sql.NullString{String: cmt.ParentID, Valid: cmt.ParentID != ""},
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.NamedExecContext(
+			ctx,
+			`INSERT INTO comments
+			(id, slug, author, owner_id, body, parent_id, version, created_at, updated_at)
+			VALUES
+			(:id, :slug, :author, :owner_id, :body, :parent_id, :version, :created_at, :updated_at)`,
+			postRow,
+		)
+		if err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, postRow)
+	})
+	if err != nil {
+		return comment.Comment{}, fmt.Errorf("failed to insert comment: %w", err)
+	}
+
+	return convertCommentRowToComment(postRow), nil
+}
+
+// UpdateComment changes the fields of a comment set in the update and bumps its version and updated_at,
+// leaving every other column as it is. A non-zero version must match the comment's current version.
+// The new content is recorded as a revision in the same transaction.
+func (d *Database) UpdateComment(
+	ctx context.Context,
+	id string,
+	version int,
+	update comment.Update,
+) (comment.Comment, error) {
+	var updatedRow CommentRow
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE comments SET
+			slug = COALESCE(?, slug),
+			body = COALESCE(?, body),
+			version = version + 1,
+			updated_at = ?
+			WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`,
+			update.Slug,
+			update.Body,
+			toMicros(d.now()),
+			id,
+			version,
+			version,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return missingOrChanged(ctx, tx, id, version)
+		}
+
+		if err := tx.GetContext(
+			ctx,
+			&updatedRow,
+			`SELECT `+commentColumns+` FROM comments WHERE id = ?`,
+			id,
+		); err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, updatedRow)
+	})
+	if err != nil {
+		return comment.Comment{}, fmt.Errorf("failed to update comment: %w", err)
+	}
+
+	return convertCommentRowToComment(updatedRow), nil
+}
+
+// DeleteComment soft deletes a comment by stamping its deleted_at column.
+// A non-zero version must match the comment's current version.
+func (d *Database) DeleteComment(ctx context.Context, id string, version int) error {
+	res, err := d.Client.ExecContext(
+		ctx,
+		`UPDATE comments SET deleted_at = ?
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`,
+		toMicros(d.now()),
+		id,
+		version,
+		version,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete comment from database: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete comment from database: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("failed to delete comment from database: %w", missingOrChanged(ctx, d.Client, id, version))
+	}
+	return nil
+}
+
+// missingOrChanged explains why a write to a comment matched no rows: either the comment
+// doesn't exist or, when the write asked for a version, the comment is at another one
+func missingOrChanged(ctx context.Context, q sqlx.QueryerContext, id string, version int) error {
+	if version == 0 {
+		return comment.ErrCommentNotFound
+	}
+	var exists bool
+	if err := sqlx.GetContext(
+		ctx,
+		q,
+		&exists,
+		`SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND deleted_at IS NULL)`,
+		id,
+	); err != nil {
+		return err
+	}
+	if exists {
+		return comment.ErrVersionMismatch
+	}
+	return comment.ErrCommentNotFound
+}
+
+// RestoreComment clears the deleted_at column of a soft deleted comment
+func (d *Database) RestoreComment(ctx context.Context, id string) (comment.Comment, error) {
+	var cmtRow CommentRow
+	err := d.Client.GetContext(
+		ctx,
+		&cmtRow,
+		`UPDATE comments SET deleted_at = NULL
+		WHERE id = ? AND deleted_at IS NOT NULL
+		RETURNING `+commentColumns,
+		id,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return comment.Comment{}, fmt.Errorf("failed to restore comment: %w", comment.ErrCommentNotFound)
+		}
+		return comment.Comment{}, fmt.Errorf("failed to restore comment: %w", err)
+	}
+
+	return convertCommentRowToComment(cmtRow), nil
+}
+
+// PurgeComments permanently removes comments that were soft deleted before the given time.
+// The replies beneath a purged comment, which have been hidden since it was deleted, go with it.
+func (d *Database) PurgeComments(ctx context.Context, before time.Time) (int64, error) {
+	res, err := d.Client.ExecContext(
+		ctx,
+		`WITH RECURSIVE purged(id) AS (
+			SELECT id FROM comments WHERE deleted_at IS NOT NULL AND deleted_at < ?
+			UNION
+			SELECT comments.id FROM comments JOIN purged ON comments.parent_id = purged.id
+		)
+		DELETE FROM comments WHERE id IN (SELECT id FROM purged)`,
+		toMicros(before),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted comments: %w", err)
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted comments: %w", err)
+	}
+	return purged, nil
+}
+
+// ListComments returns up to limit comments for a slug in creation order, starting after the cursor
+func (d *Database) ListComments(
+	ctx context.Context,
+	slug string,
+	after comment.Cursor,
+	limit int,
+) ([]comment.Comment, error) {
+	query := `SELECT ` + commentColumns + `
+		FROM comments
+		WHERE slug = ? AND deleted_at IS NULL`
+	args := []interface{}{slug}
+	if !after.IsZero() {
+		query += ` AND (created_at, id) > (?, ?)`
+		args = append(args, toMicros(after.CreatedAt), after.ID)
+	}
+	query += ` ORDER BY created_at ASC, id ASC LIMIT ?`
+	args = append(args, limit)
+
+	var cmtRows []CommentRow
+	if err := d.Client.SelectContext(ctx, &cmtRows, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list comments: %w", err)
+	}
+
+	return convertCommentRowsToComments(cmtRows), nil
+}
+
+// ListCommentTree loads a slug's comment tree with a single recursive query.
+// SQLite has no lateral joins, so each level is limited per parent with a correlated subquery.
+// Deleted comments are left out along with all of their replies, as are replies moved to another slug.
+func (d *Database) ListCommentTree(ctx context.Context, q comment.TreeQuery) ([]comment.Comment, error) {
+	root := `parent_id IS NULL`
+	args := []interface{}{q.Slug}
+	if q.ParentID != "" {
+		root = `parent_id = ?`
+		args = append(args, q.ParentID)
+	}
+	if !q.After.IsZero() {
+		root += ` AND (created_at, id) > (?, ?)`
+		args = append(args, toMicros(q.After.CreatedAt), q.After.ID)
+	}
+	args = append(args, q.Limit, q.Slug, q.Limit, q.Depth)
+
+	var cmtRows []CommentRow
+	err := d.Client.SelectContext(
+		ctx,
+		&cmtRows,
+		`WITH RECURSIVE tree AS (
+			SELECT * FROM (
+				SELECT `+commentColumns+`, 1 AS depth
+				FROM comments
+				WHERE slug = ? AND deleted_at IS NULL AND `+root+`
+				ORDER BY created_at ASC, id ASC
+				LIMIT ?
+			)
+			UNION ALL
+			SELECT c.id, c.slug, c.body, c.author, c.owner_id, c.parent_id, c.version, c.created_at, c.updated_at, tree.depth + 1
+			FROM tree
+			JOIN comments c ON c.parent_id = tree.id
+			WHERE c.id IN (
+				SELECT id
+				FROM comments
+				WHERE slug = ? AND parent_id = tree.id AND deleted_at IS NULL
+				ORDER BY created_at ASC, id ASC
+				LIMIT ?
+			)
+			AND tree.depth < ?
+		)
+		SELECT `+commentColumns+`
+		FROM tree
+		ORDER BY depth ASC, created_at ASC, id ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comment tree: %w", err)
+	}
+
+	return convertCommentRowsToComments(cmtRows), nil
+}
@@ -0,0 +1,48 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment/commenttest"
+)
+
+// openTestDatabase returns a fresh, migrated in-memory SQLite database
+func openTestDatabase(t *testing.T) *Database {
+	db, err := NewDatabase(":memory:")
+	if err != nil {
+		t.Fatalf("could not open the database: %v", err)
+	}
+	t.Cleanup(func() { db.Client.Close() })
+
+	if err := db.MigrateDB(); err != nil {
+		t.Fatalf("could not migrate the database: %v", err)
+	}
+	return db
+}
+
+// TestCommentDatabaseConformance runs the shared comment.Store suite against a fresh SQLite database.
+func TestCommentDatabaseConformance(t *testing.T) {
+	commenttest.RunStoreTests(t, func(t *testing.T) comment.Store {
+		return openTestDatabase(t)
+	})
+}
+
+// TestMigrateDownAndUp checks that every migration can be rolled back and applied again
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTestDatabase(t)
+	m, err := db.Migrator()
+	if err != nil {
+		t.Fatalf("could not create the migrator: %v", err)
+	}
+
+	if err := m.Down(); err != nil {
+		t.Fatalf("could not roll back the migrations: %v", err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("could not apply the migrations again: %v", err)
+	}
+	commenttest.RunStoreTests(t, func(t *testing.T) comment.Store {
+		return db
+	})
+}
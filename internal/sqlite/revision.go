@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/jmoiron/sqlx"
+)
+
+// RevisionRow models the columns within comment_revisions table in the database
+type RevisionRow struct {
+	CommentID string `db:"comment_id"`
+	Revision  int
+	Slug      string
+	Author    string
+	Body      string
+	CreatedAt int64 `db:"created_at"`
+}
+
+func convertRevisionRowToRevision(r RevisionRow) comment.Revision {
+	return comment.Revision{
+		CommentID: r.CommentID,
+		Revision:  r.Revision,
+		Slug:      r.Slug,
+		Author:    r.Author,
+		Body:      r.Body,
+		CreatedAt: fromMicros(r.CreatedAt),
+	}
+}
+
+// insertRevision records the current content of a comment as its next revision.
+// It must run in the same transaction as the write it records.
+func insertRevision(ctx context.Context, tx *sqlx.Tx, row CommentRow) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO comment_revisions
+		(comment_id, revision, slug, author, body, created_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?
+		FROM comment_revisions
+		WHERE comment_id = ?`,
+		row.ID,
+		row.Slug,
+		row.Author,
+		row.Body,
+		row.UpdatedAt,
+		row.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record comment revision: %w", err)
+	}
+	return nil
+}
+
+// ListRevisions returns every revision of a comment, oldest first.
+// Deleted comments have their history hidden along with the comment itself.
+func (d *Database) ListRevisions(ctx context.Context, id string) ([]comment.Revision, error) {
+	var revRows []RevisionRow
+	err := d.Client.SelectContext(
+		ctx,
+		&revRows,
+		`SELECT r.comment_id, r.revision, r.slug, r.author, r.body, r.created_at
+		FROM comment_revisions r
+		JOIN comments c ON c.id = r.comment_id
+		WHERE r.comment_id = ? AND c.deleted_at IS NULL
+		ORDER BY r.revision ASC`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comment revisions: %w", err)
+	}
+	// Every live comment has at least the revision written when it was posted
+	if len(revRows) == 0 {
+		return nil, fmt.Errorf("failed to list comment revisions: %w", comment.ErrCommentNotFound)
+	}
+
+	revs := make([]comment.Revision, 0, len(revRows))
+	for _, row := range revRows {
+		revs = append(revs, convertRevisionRowToRevision(row))
+	}
+	return revs, nil
+}
@@ -0,0 +1,40 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+)
+
+// SearchComments narrows the live comments down with the search's filters in SQL, then matches
+// and ranks their bodies with comment.MatchComments, as SQLite has no Postgres style full-text search
+func (d *Database) SearchComments(ctx context.Context, q comment.SearchQuery) ([]comment.SearchResult, error) {
+	query := `SELECT ` + commentColumns + `
+		FROM comments
+		WHERE deleted_at IS NULL`
+	var args []interface{}
+	if q.Slug != "" {
+		query += ` AND slug = ?`
+		args = append(args, q.Slug)
+	}
+	if q.Author != "" {
+		query += ` AND author = ?`
+		args = append(args, q.Author)
+	}
+	if !q.From.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, toMicros(q.From))
+	}
+	if !q.To.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, toMicros(q.To))
+	}
+
+	var cmtRows []CommentRow
+	if err := d.Client.SelectContext(ctx, &cmtRows, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to search comments: %w", err)
+	}
+
+	return comment.MatchComments(convertCommentRowsToComments(cmtRows), q), nil
+}
@@ -0,0 +1,128 @@
+// Package sqlite provides a comment.Store backed by a SQLite database file.
+// It uses a pure Go driver, so the server still builds with CGO_ENABLED=0.
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// Database is a struct that represents a SQLite database connection.
+type Database struct {
+	Client *sqlx.DB
+
+	mu      sync.Mutex
+	lastNow time.Time
+}
+
+// NewDatabase opens the SQLite database at path, creating the file if it doesn't exist.
+// Passing ":memory:" gives a private database that lives as long as the connection.
+func NewDatabase(path string) (*Database, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
+	dbConn, err := sqlx.Connect("sqlite", dsn)
+	if err != nil {
+		return &Database{}, fmt.Errorf("could not open the sqlite database: %w", err)
+	}
+	// SQLite allows a single writer at a time; funnelling everything through one connection
+	// avoids "database is locked" errors and keeps ":memory:" databases on a single connection.
+	dbConn.SetMaxOpenConns(1)
+
+	return &Database{
+		Client: dbConn,
+	}, nil
+}
+
+// Close closes the database, waiting for running queries to finish.
+func (d *Database) Close() error {
+	return d.Client.Close()
+}
+
+// Ping checks that the database can still be reached.
+func (d *Database) Ping(ctx context.Context) error {
+	return d.Client.DB.PingContext(ctx)
+}
+
+// Migrator returns a golang-migrate instance for the migrations embedded in the binary.
+// Closing it closes the database connection too, so the server never does.
+func (d *Database) Migrator() (*migrate.Migrate, error) {
+	driver, err := migratesqlite.WithInstance(d.Client.DB, &migratesqlite.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the sqlite driver: %w", err)
+	}
+
+	src, err := migrations.Source(migrations.SQLite())
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "sqlite", driver)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the migrator: %w", err)
+	}
+	return m, nil
+}
+
+// MigrateDB applies the SQLite migrations embedded in the binary.
+// The schema version reached is logged whether or not the migration succeeds.
+func (d *Database) MigrateDB() error {
+	log.Info("migrating our sqlite database")
+
+	m, err := d.Migrator()
+	if err != nil {
+		return err
+	}
+
+	if err := m.Up(); err != nil {
+		if !errors.Is(err, migrate.ErrNoChange) {
+			log.WithFields(migrations.VersionFields(m)).Error("migrating the sqlite database failed")
+			return fmt.Errorf("could not run up migrations: %w", err)
+		}
+	}
+	log.WithFields(migrations.VersionFields(m)).Info("successfully migrated the sqlite database")
+
+	return nil
+}
+
+// now returns the current time at microsecond precision, always moving forward between calls
+// so that writes keep the order they were made in even when they land in the same microsecond.
+func (d *Database) now() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if !now.After(d.lastNow) {
+		now = d.lastNow.Add(time.Microsecond)
+	}
+	d.lastNow = now
+	return now
+}
+
+// inTx runs fn inside a transaction, committing when it succeeds and rolling back when it fails.
+func (d *Database) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := d.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
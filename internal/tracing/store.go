@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created for store calls
+const tracerName = "github.com/JonathanBaggott/go-rest-api-course-v2/internal/tracing"
+
+// Store - the methods of a store that are traced
+type Store interface {
+	comment.Store
+	auth.KeyStore
+}
+
+// tracedStore starts a span for every call made to the store it wraps
+type tracedStore struct {
+	next  Store
+	attrs []attribute.KeyValue
+}
+
+// InstrumentStore wraps a store so that every call to it is a client span, a child of
+// whatever span is in the context passed to the call
+func InstrumentStore(store Store, backend string) Store {
+	attrs := []attribute.KeyValue{attribute.String("store.backend", backend)}
+	switch backend {
+	case config.BackendPostgres:
+		attrs = append(attrs, semconv.DBSystemPostgreSQL)
+	case config.BackendSQLite:
+		attrs = append(attrs, semconv.DBSystemSqlite)
+	}
+	return &tracedStore{next: store, attrs: attrs}
+}
+
+// start starts the span for a call to a store method. Deferring the returned function with a
+// pointer to the method's named error result ends the span, marking it failed if the call failed.
+func (s *tracedStore) start(ctx context.Context, method string) (context.Context, func(err *error)) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "store."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.attrs...),
+		trace.WithAttributes(semconv.DBOperation(method)),
+	)
+	return ctx, func(err *error) {
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
+
+func (s *tracedStore) GetComment(ctx context.Context, id string) (_ comment.Comment, err error) {
+	ctx, end := s.start(ctx, "GetComment")
+	defer end(&err)
+	return s.next.GetComment(ctx, id)
+}
+
+func (s *tracedStore) PostComment(ctx context.Context, cmt comment.Comment) (_ comment.Comment, err error) {
+	ctx, end := s.start(ctx, "PostComment")
+	defer end(&err)
+	return s.next.PostComment(ctx, cmt)
+}
+
+func (s *tracedStore) DeleteComment(ctx context.Context, id string, version int) (err error) {
+	ctx, end := s.start(ctx, "DeleteComment")
+	defer end(&err)
+	return s.next.DeleteComment(ctx, id, version)
+}
+
+func (s *tracedStore) UpdateComment(ctx context.Context, id string, version int, update comment.Update) (_ comment.Comment, err error) {
+	ctx, end := s.start(ctx, "UpdateComment")
+	defer end(&err)
+	return s.next.UpdateComment(ctx, id, version, update)
+}
+
+func (s *tracedStore) ListComments(ctx context.Context, slug string, after comment.Cursor, limit int) (_ []comment.Comment, err error) {
+	ctx, end := s.start(ctx, "ListComments")
+	defer end(&err)
+	return s.next.ListComments(ctx, slug, after, limit)
+}
+
+func (s *tracedStore) ListCommentTree(ctx context.Context, q comment.TreeQuery) (_ []comment.Comment, err error) {
+	ctx, end := s.start(ctx, "ListCommentTree")
+	defer end(&err)
+	return s.next.ListCommentTree(ctx, q)
+}
+
+func (s *tracedStore) RestoreComment(ctx context.Context, id string) (_ comment.Comment, err error) {
+	ctx, end := s.start(ctx, "RestoreComment")
+	defer end(&err)
+	return s.next.RestoreComment(ctx, id)
+}
+
+func (s *tracedStore) PurgeComments(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, end := s.start(ctx, "PurgeComments")
+	defer end(&err)
+	return s.next.PurgeComments(ctx, before)
+}
+
+func (s *tracedStore) ListRevisions(ctx context.Context, id string) (_ []comment.Revision, err error) {
+	ctx, end := s.start(ctx, "ListRevisions")
+	defer end(&err)
+	return s.next.ListRevisions(ctx, id)
+}
+
+func (s *tracedStore) SearchComments(ctx context.Context, q comment.SearchQuery) (_ []comment.SearchResult, err error) {
+	ctx, end := s.start(ctx, "SearchComments")
+	defer end(&err)
+	return s.next.SearchComments(ctx, q)
+}
+
+func (s *tracedStore) CreateAPIKey(ctx context.Context, key auth.APIKey, hash string) (_ auth.APIKey, err error) {
+	ctx, end := s.start(ctx, "CreateAPIKey")
+	defer end(&err)
+	return s.next.CreateAPIKey(ctx, key, hash)
+}
+
+func (s *tracedStore) GetAPIKeyByHash(ctx context.Context, hash string) (_ auth.APIKey, err error) {
+	ctx, end := s.start(ctx, "GetAPIKeyByHash")
+	defer end(&err)
+	return s.next.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *tracedStore) ListAPIKeys(ctx context.Context) (_ []auth.APIKey, err error) {
+	ctx, end := s.start(ctx, "ListAPIKeys")
+	defer end(&err)
+	return s.next.ListAPIKeys(ctx)
+}
+
+func (s *tracedStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	ctx, end := s.start(ctx, "RevokeAPIKey")
+	defer end(&err)
+	return s.next.RevokeAPIKey(ctx, id, at)
+}
+
+func (s *tracedStore) TouchAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	ctx, end := s.start(ctx, "TouchAPIKey")
+	defer end(&err)
+	return s.next.TouchAPIKey(ctx, id, at)
+}
@@ -0,0 +1,93 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps every finished span in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestSpansFollowTheContext(t *testing.T) {
+	recorder := recordSpans(t)
+	store := tracing.InstrumentStore(memory.NewStore(), config.BackendMemory)
+	svc := comment.NewService(store, config.Comments{})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	ctx = auth.NewContext(ctx, auth.Claims{Subject: "alice"})
+	_, err := svc.PostComment(ctx, comment.Comment{Slug: "slug", Body: "body"})
+	require.NoError(t, err)
+	parent.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, "comment.Service.PostComment")
+	require.Contains(t, spans, "store.PostComment")
+
+	service, stored := spans["comment.Service.PostComment"], spans["store.PostComment"]
+	assert.Equal(t, parent.SpanContext().SpanID(), service.Parent().SpanID())
+	assert.Equal(t, service.SpanContext().SpanID(), stored.Parent().SpanID())
+	assert.Equal(t, parent.SpanContext().TraceID(), stored.SpanContext().TraceID())
+	assert.Equal(t, trace.SpanKindClient, stored.SpanKind())
+}
+
+func TestFailedStoreCallsAreMarked(t *testing.T) {
+	recorder := recordSpans(t)
+	store := tracing.InstrumentStore(memory.NewStore(), config.BackendMemory)
+
+	_, err := store.GetComment(context.Background(), "00000000-0000-0000-0000-000000000000")
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "store.GetComment", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
+
+func TestSetupPropagatesTraceContext(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	shutdown, err := tracing.Setup(context.Background(), config.Tracing{Exporter: config.ExporterNone, ServiceName: "test"})
+	require.NoError(t, err)
+	defer shutdown(context.Background())
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	sc := trace.SpanContextFromContext(ctx)
+	assert.True(t, sc.IsRemote())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+}
+
+func TestSetupRejectsUnknownExporters(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), config.Tracing{Exporter: "zipkin", ServiceName: "test"})
+	assert.ErrorContains(t, err, "zipkin")
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/gorilla/mux"
+)
+
+// APIKeyService defines the interface for managing and checking API keys
+type APIKeyService interface {
+	CreateKey(ctx context.Context, key auth.APIKey) (auth.APIKey, string, error)
+	ListKeys(ctx context.Context) ([]auth.APIKey, error)
+	RevokeKey(ctx context.Context, id string) error
+	Authenticate(ctx context.Context, secret string) (auth.Claims, error)
+}
+
+// CreateAPIKeyRequest represents the structure of the request body for a new API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=comments:write comments:moderate apikeys:admin"`
+	// ExpiresAt is optional; keys without it never expire
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse represents the response structure for a new API key.
+// Key is the only time the key itself is returned.
+type CreateAPIKeyResponse struct {
+	APIKey auth.APIKey
+	Key    string
+}
+
+// ListAPIKeysResponse represents the response structure when listing API keys
+type ListAPIKeysResponse struct {
+	APIKeys []auth.APIKey
+}
+
+// CreateAPIKey handles the HTTP POST request for creating a new API key
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateRequest(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		writeError(w, r, comment.InvalidFields("invalid API key", comment.FieldError{Field: "expires_at", Reason: "must be in the future"}))
+		return
+	}
+
+	claims, _ := auth.FromContext(r.Context())
+	key, secret, err := h.APIKeys.CreateKey(r.Context(), auth.APIKey{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CreatedBy: claims.Subject,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: key, Key: secret}); err != nil {
+		panic(err)
+	}
+}
+
+// ListAPIKeys handles the HTTP GET request for listing every API key
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.APIKeys.ListKeys(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(ListAPIKeysResponse{APIKeys: keys}); err != nil {
+		panic(err)
+	}
+}
+
+// RevokeAPIKey handles the HTTP DELETE request for revoking an API key by ID
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, "api key id is required")
+		return
+	}
+
+	if err := h.APIKeys.RevokeKey(r.Context(), id); err != nil {
+		if errors.Is(err, auth.ErrAPIKeyNotFound) {
+			writeProblem(w, r, http.StatusNotFound, "api key not found")
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(Response{Message: "Successfully revoked"}); err != nil {
+		panic(err)
+	}
+}
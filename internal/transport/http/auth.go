@@ -1,60 +1,112 @@
 package http
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
 )
 
-func JWTAuth(original func(w http.ResponseWriter, r *http.Request),
+// APIKeyHeader is the header server-to-server clients send their API key in
+const APIKeyHeader = "X-API-Key"
+
+// TokenVerifier checks bearer tokens and returns the claims they carry
+type TokenVerifier interface {
+	Verify(ctx context.Context, accessToken string) (auth.Claims, error)
+}
+
+// Authorize wraps a handler so that it only runs for requests authenticated with either an
+// API key or a bearer token that grants every one of the required scopes.
+// The caller's claims are stored in the request context; handlers read them with auth.FromContext.
+func (h *Handler) Authorize(original func(w http.ResponseWriter, r *http.Request), required ...string,
 ) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get the value of the "Authorization" header from the request
-		authHeader := r.Header["Authorization"]
-		if authHeader == nil {
-			// If the header is missing, respond with "not authorized" and HTTP status code 401 (Unauthorized)
-			http.Error(w, "not authorized", http.StatusUnauthorized)
-			return
+		var claims auth.Claims
+		var ok bool
+		if r.Header.Get(APIKeyHeader) != "" {
+			claims, ok = h.APIKeyAuth(w, r)
+		} else {
+			claims, ok = h.JWTAuth(w, r)
 		}
-
-		// Split the "Authorization" header value into two parts: the scheme ("Bearer") and the token string
-		authHeaderParts := strings.Split(authHeader[0], " ")
-		// If the header value doesn't have two parts or the scheme is not "Bearer",
-		// respond with "not authorized" and HTTP status code 401 (Unauthorized)
-		if len(authHeaderParts) != 2 || strings.ToLower(authHeaderParts[0]) != "bearer" {
-			http.Error(w, "not authorized", http.StatusUnauthorized)
+		if !ok {
 			return
 		}
 
-		// Validate the incoming token by calling the validateToken function
-		if validateToken(authHeaderParts[1]) {
-			// If the token is valid, call the original handler function with the provided response writer and request
-			original(w, r)
-		} else {
-			// If the token is not valid, respond with "not authorized" and HTTP status code 401 (Unauthorized)
-			http.Error(w, "not authorized", http.StatusUnauthorized)
+		// A caller that lacks a required scope is forbidden (HTTP status code 403) rather than unauthorized
+		if missing := claims.MissingScopes(required...); len(missing) > 0 {
+			writeForbidden(w, r, missing)
 			return
 		}
+
+		// Call the original handler with the verified claims available in the request context
+		original(w, r.WithContext(auth.NewContext(r.Context(), claims)))
 	}
 }
 
-func validateToken(accessToken string) bool {
-	var mySigningKey = []byte("missionimpossible")
-	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			// If the token's signing method is not HMAC, return an error indicating that the auth token could not be validated
-			return nil, errors.New("could not validate auth token")
-		}
+// JWTAuth authenticates a request carrying a bearer token, returning the token's claims.
+// When the token is missing or invalid it writes a 401 response and returns false.
+func (h *Handler) JWTAuth(w http.ResponseWriter, r *http.Request) (auth.Claims, bool) {
+	// Get the value of the "Authorization" header from the request
+	authHeader := r.Header["Authorization"]
+	if authHeader == nil {
+		// If the header is missing, respond with "not authorized" and HTTP status code 401 (Unauthorized)
+		writeUnauthorized(w, r, "not authorized")
+		return auth.Claims{}, false
+	}
 
-		return mySigningKey, nil
-	})
+	// Split the "Authorization" header value into two parts: the scheme ("Bearer") and the token string
+	authHeaderParts := strings.Split(authHeader[0], " ")
+	// If the header value doesn't have two parts or the scheme is not "Bearer",
+	// respond with "not authorized" and HTTP status code 401 (Unauthorized)
+	if len(authHeaderParts) != 2 || strings.ToLower(authHeaderParts[0]) != "bearer" {
+		writeUnauthorized(w, r, "not authorized")
+		return auth.Claims{}, false
+	}
+
+	// Verify the token's signature and claims
+	claims, err := h.Auth.Verify(r.Context(), authHeaderParts[1])
+	if err != nil {
+		// If the token is not valid, respond with HTTP status code 401 (Unauthorized).
+		// The reason is only logged, as it could tell an attacker how close a forged token came.
+		logging.FromContext(r.Context()).WithError(err).Info("rejected bearer token")
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="the access token is invalid"`)
+		writeProblem(w, r, http.StatusUnauthorized, "the access token is invalid")
+		return auth.Claims{}, false
+	}
+	return claims, true
+}
 
+// APIKeyAuth authenticates a request carrying an API key, returning claims with the key's scopes.
+// When the key can't be used it writes a 401 response and returns false.
+func (h *Handler) APIKeyAuth(w http.ResponseWriter, r *http.Request) (auth.Claims, bool) {
+	claims, err := h.APIKeys.Authenticate(r.Context(), r.Header.Get(APIKeyHeader))
 	if err != nil {
-		// If there was an error parsing the token, return false indicating the token is not valid
-		return false
+		if errors.Is(err, auth.ErrInvalidAPIKey) {
+			// As with bearer tokens, why the key was rejected (e.g. revoked or expired) is only logged
+			logging.FromContext(r.Context()).WithError(err).Info("rejected api key")
+			w.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+			writeProblem(w, r, http.StatusUnauthorized, "the API key is invalid")
+		} else {
+			writeError(w, r, err)
+		}
+		return auth.Claims{}, false
 	}
+	return claims, true
+}
+
+// writeUnauthorized responds with a 401 problem telling the client to authenticate with a bearer token
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeProblem(w, r, http.StatusUnauthorized, detail)
+}
 
-	return token.Valid
+// writeForbidden responds with a 403 problem listing the scopes the token is missing
+func writeForbidden(w http.ResponseWriter, r *http.Request, missing []string) {
+	scopes := strings.Join(missing, " ")
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scopes))
+	writeProblem(w, r, http.StatusForbidden, "missing the required scope: "+scopes)
 }
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	h, _ := newTestHandler(t, config.Comments{})
+	var seen auth.Claims
+	handler := h.Authorize(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = auth.FromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}, auth.ScopeCommentsModerate)
+	h.Router.HandleFunc("/moderated", handler)
+
+	tests := []struct {
+		name          string
+		authorization string
+		apiKey        string
+		wantStatus    int
+		wantChallenge string
+		wantDetail    string
+	}{
+		{
+			name:          "no token",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: "Bearer",
+			wantDetail:    "not authorized",
+		},
+		{
+			name:          "not a bearer token",
+			authorization: "Basic YWxpY2U6c2VjcmV0",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: "Bearer",
+			wantDetail:    "not authorized",
+		},
+		{
+			name:          "invalid token",
+			authorization: "Bearer forged-token",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer error="invalid_token", error_description="the access token is invalid"`,
+			wantDetail:    "the access token is invalid",
+		},
+		{
+			name:          "token without the scope",
+			authorization: "Bearer " + writerToken,
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope", scope="comments:moderate"`,
+			wantDetail:    "missing the required scope: comments:moderate",
+		},
+		{
+			name:          "token with the scope",
+			authorization: "bearer " + moderatorToken,
+			wantStatus:    http.StatusNoContent,
+		},
+		{
+			name:          "unknown API key",
+			apiKey:        "guessed-key",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `APIKey header="X-API-Key"`,
+			wantDetail:    "the API key is invalid",
+		},
+		{
+			name:          "revoked API key",
+			apiKey:        revokedAPIKey,
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `APIKey header="X-API-Key"`,
+			wantDetail:    "the API key is invalid",
+		},
+		{
+			name:       "API key with the scope",
+			apiKey:     moderatorAPIKey,
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = auth.Claims{}
+			var headers []string
+			if tt.authorization != "" {
+				headers = []string{"Authorization", tt.authorization}
+			}
+			if tt.apiKey != "" {
+				headers = append(headers, APIKeyHeader, tt.apiKey)
+			}
+			rec := serve(h, http.MethodGet, "/moderated", "", headers...)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantChallenge, rec.Header().Get("WWW-Authenticate"))
+			if tt.wantStatus == http.StatusNoContent {
+				assert.Contains(t, []string{"mod", "apikey:mod"}, seen.Subject)
+				return
+			}
+			assert.Empty(t, seen.Subject, "the handler must not run")
+			problem := decodeProblem(t, rec)
+			assert.Equal(t, tt.wantDetail, problem.Detail)
+			// Why verification failed is only logged
+			assert.NotContains(t, rec.Body.String(), "internal-key-1")
+			assert.NotContains(t, rec.Body.String(), "revoked")
+		})
+	}
+}
@@ -3,12 +3,13 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
-
-	"log"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 )
 
@@ -16,8 +17,14 @@ import (
 type CommentService interface {
 	PostComment(context.Context, comment.Comment) (comment.Comment, error)
 	GetComment(ctx context.Context, ID string) (comment.Comment, error)
-	UpdateComment(ctx context.Context, ID string, newCmt comment.Comment) (comment.Comment, error)
-	DeleteComment(ctx context.Context, ID string) error
+	UpdateComment(ctx context.Context, ID string, update comment.Update, ifMatch comment.IfMatch) (comment.Comment, error)
+	DeleteComment(ctx context.Context, ID string, ifMatch comment.IfMatch) error
+	RestoreComment(ctx context.Context, ID string) (comment.Comment, error)
+	ListRevisions(ctx context.Context, ID string) ([]comment.Revision, error)
+	DiffRevisions(ctx context.Context, ID string, from, to int) (comment.RevisionDiff, error)
+	ListComments(ctx context.Context, slug string, cursor string, limit int) (comment.Page, error)
+	GetCommentTree(ctx context.Context, slug, parentID, cursor string, depth, limit int) (comment.Tree, error)
+	SearchComments(ctx context.Context, q comment.SearchQuery, cursor string, limit int) (comment.SearchPage, error)
 }
 
 // Response represents the response structure
@@ -25,20 +32,55 @@ type Response struct {
 	Message string
 }
 
-// PostCommentRequest represents the structure of the request body for a new comment
+// CommentTreeResponse represents the response structure when fetching the comments of a slug as a tree
+type CommentTreeResponse struct {
+	Comments   []comment.Thread
+	NextCursor string
+}
+
+// ListCommentsResponse represents the response structure when listing the comments of a slug
+type ListCommentsResponse struct {
+	Comments   []comment.Comment
+	NextCursor string
+}
+
+// SearchCommentsResponse represents the response structure when searching comments
+type SearchCommentsResponse struct {
+	Results    []comment.SearchResult
+	NextCursor string
+}
+
+// PostCommentRequest represents the structure of the request body for a new comment.
+// There is no author field: comments are always attributed to the subject of the caller's token.
 type PostCommentRequest struct {
-	Slug   string `json:"slug" validate:"required"`
-	Author string `json:"author" validate:"required"`
-	Body   string `json:"body" validate:"required"`
+	Slug string `json:"slug" validate:"required"`
+	Body string `json:"body" validate:"required"`
+	// ParentID is set when the comment is a reply to another comment on the same slug
+	ParentID string `json:"parent_id" validate:"omitempty,uuid"`
+}
+
+// UpdateCommentRequest represents the structure of the request body when replacing a comment with PUT.
+// Only the slug and body can be changed; every other field of a comment is managed by the server.
+type UpdateCommentRequest struct {
+	Slug string `json:"slug" validate:"required"`
+	Body string `json:"body" validate:"required"`
+}
+
+// convertUpdateCommentRequestToUpdate turns a PUT request body into an update replacing both fields
+func convertUpdateCommentRequestToUpdate(c UpdateCommentRequest) comment.Update {
+	return comment.Update{
+		Slug: &c.Slug,
+		Body: &c.Body,
+	}
 }
 
 // convertPostCommentRequestToComment is a helper function that takes an instance of the 'PostCommentRequest' struct as input,
 // and converts it into an instance of the 'comment.Comment' struct.
 func convertPostCommentRequestToComment(c PostCommentRequest) comment.Comment {
 	return comment.Comment{
-		Slug:   c.Slug,
-		Author: c.Author,
-		Body:   c.Body,
+		Slug:     c.Slug,
+		Body:     c.Body,
+		ParentID: c.ParentID,
 	}
 }
 
@@ -47,16 +89,14 @@ func (h *Handler) PostComment(w http.ResponseWriter, r *http.Request) {
 	var cmt PostCommentRequest
 
 	// Decode the request body into a Comment struct
-	if err := json.NewDecoder(r.Body).Decode(&cmt); err != nil {
+	if err := decodeJSONBody(r, &cmt); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	// Create a new instance of the validator and assign it to 'validate'
-	validate := validator.New()
-	// Validate the fields of the 'cmt' struct using the 'validate' instance
-	err := validate.Struct(cmt)
-	if err != nil {
-		http.Error(w, "not a valid comment", http.StatusBadRequest)
+	// Validate the fields of the 'cmt' struct, reporting every field that fails
+	if err := validateRequest(cmt); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -65,33 +105,40 @@ func (h *Handler) PostComment(w http.ResponseWriter, r *http.Request) {
 	// Call the PostComment method of the CommentService to create a new comment
 	postedComment, err := h.Service.PostComment(r.Context(), convertedComment)
 	if err != nil {
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
 
 	// Encode the comment as JSON and send it in the response
+	setETag(w, postedComment)
 	if err := json.NewEncoder(w).Encode(postedComment); err != nil {
 		panic(err)
 	}
 
 }
 
-// GetComment handles the HTTP GET request for retrieving a comment by ID
+// GetComment handles the HTTP GET request for retrieving a comment by ID.
+// A client that already has the current version, according to If-None-Match, gets a 304 Not Modified without a body.
 func (h *Handler) GetComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	// Check if the comment ID is provided
 	if id == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
 		return
 	}
 
 	// Call the GetComment method of the CommentService to retrieve the comment by ID
 	cmt, err := h.Service.GetComment(r.Context(), id)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, cmt)
+	if ifNoneMatch(r, cmt) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -101,58 +148,103 @@ func (h *Handler) GetComment(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// UpdateComment handles the HTTP PUT request for updating a comment by ID
+// UpdateComment handles the HTTP PUT request for updating a comment by ID.
+// With an If-Match header the comment is only updated while it's still at a version the header lists.
 func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	// Check if the comment ID is provided
 	if id == "" {
-		// Set the HTTP response status code to indicate a 400 Bad Request.
-		// This status code indicates that the server cannot process the client's request due to a client error.
-		w.WriteHeader(http.StatusBadRequest)
+		// Respond with a 400 Bad Request problem, since the server cannot process the request without an ID
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
 		return
 	}
 
-	var cmt comment.Comment
+	var req UpdateCommentRequest
 
-	// Decode the request body into a Comment struct
-	if err := json.NewDecoder(r.Body).Decode(&cmt); err != nil {
+	// Decode the request body into an UpdateCommentRequest struct
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Both fields are replaced, so both are required
+	if err := validateRequest(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Update the comment by ID using the UpdateComment method of the CommentService
+	cmt, err := h.Service.UpdateComment(r.Context(), id, convertUpdateCommentRequestToUpdate(req), parseIfMatch(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the updated comment as JSON and send it in the response
+	setETag(w, cmt)
+	if err := json.NewEncoder(w).Encode(cmt); err != nil {
+		panic(err)
+	}
+}
+
+// PatchComment handles the HTTP PATCH request for changing some of the fields of a comment by ID.
+// The request body is a JSON merge patch (RFC 7396): fields it leaves out are kept as they are.
+// With an If-Match header the comment is only updated while it's still at a version the header lists.
+func (h *Handler) PatchComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Check if the comment ID is provided
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
+		return
+	}
+
+	// Merge patches are the only kind of patch we understand
+	if !isMergePatch(r) {
+		w.Header().Set("Accept-Patch", MergePatchContentType)
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "the request body must be a JSON merge patch ("+MergePatchContentType+")")
+		return
+	}
+
+	update, err := decodeMergePatch(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Update the comment by ID using the UpdateComment method of the CommentService
-	cmt, err := h.Service.UpdateComment(r.Context(), id, cmt)
+	cmt, err := h.Service.UpdateComment(r.Context(), id, update, parseIfMatch(r))
 	if err != nil {
-		log.Print(err)
-		// Set the HTTP response status code to indicate a 500 Internal Server Error.
-		// This status indicates that an unexpected error occurred on the server side.
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	// Encode the updated comment as JSON and send it in the response
+	setETag(w, cmt)
 	if err := json.NewEncoder(w).Encode(cmt); err != nil {
 		panic(err)
 	}
 }
 
-// DeleteComment handles the HTTP DELETE request for deleting a comment by ID
+// DeleteComment handles the HTTP DELETE request for deleting a comment by ID.
+// With an If-Match header the comment is only deleted while it's still at a version the header lists.
 func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	// Check if the comment ID is provided
 	if id == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
 		return
 	}
 
 	// Call the DeleteComment method of the CommentService to delete the comment by ID
-	err := h.Service.DeleteComment(r.Context(), id)
+	err := h.Service.DeleteComment(r.Context(), id, parseIfMatch(r))
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -161,3 +253,268 @@ func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 }
+
+// RestoreComment handles the HTTP POST request for restoring a soft deleted comment by ID
+func (h *Handler) RestoreComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Check if the comment ID is provided
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
+		return
+	}
+
+	// Call the RestoreComment method of the CommentService to bring the comment back
+	cmt, err := h.Service.RestoreComment(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the restored comment as JSON and send it in the response
+	setETag(w, cmt)
+	if err := json.NewEncoder(w).Encode(cmt); err != nil {
+		panic(err)
+	}
+}
+
+// ListRevisions handles the HTTP GET request for retrieving the edit history of a comment
+func (h *Handler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Check if the comment ID is provided
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
+		return
+	}
+
+	// Call the ListRevisions method of the CommentService to retrieve every revision of the comment
+	revs, err := h.Service.ListRevisions(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the revisions as JSON and send them in the response
+	if err := json.NewEncoder(w).Encode(revs); err != nil {
+		panic(err)
+	}
+}
+
+// DiffRevisions handles the HTTP GET request for comparing two revisions of a comment.
+// The revisions to compare are given by the required from and to query parameters.
+func (h *Handler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Check if the comment ID is provided
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, "comment id is required")
+		return
+	}
+
+	// Both revision numbers are required
+	query := r.URL.Query()
+	from, err := positiveIntParam(query, "from")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, err := positiveIntParam(query, "to")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if from == 0 || to == 0 {
+		writeError(w, r, comment.InvalidFields(
+			"from and to query parameters are required",
+			comment.FieldError{Field: "from", Reason: "is required"},
+			comment.FieldError{Field: "to", Reason: "is required"},
+		))
+		return
+	}
+
+	// Call the DiffRevisions method of the CommentService to compare the two revisions
+	diff, err := h.Service.DiffRevisions(r.Context(), id, from, to)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the diff as JSON and send it in the response
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		panic(err)
+	}
+}
+
+// ListComments handles the HTTP GET request for listing the comments of a slug.
+// The slug is required; cursor and limit are optional query parameters used for pagination.
+func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	slug := query.Get("slug")
+
+	// Check if the slug is provided
+	if slug == "" {
+		writeError(w, r, comment.InvalidFields(
+			"slug query parameter is required",
+			comment.FieldError{Field: "slug", Reason: "is required"},
+		))
+		return
+	}
+
+	// Parse the optional page size, leaving it at zero so the service applies its default
+	limit, err := positiveIntParam(query, "limit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Call the ListComments method of the CommentService to retrieve a page of comments
+	page, err := h.Service.ListComments(r.Context(), slug, query.Get("cursor"), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the page of comments as JSON and send it in the response
+	if err := json.NewEncoder(w).Encode(ListCommentsResponse{
+		Comments:   page.Comments,
+		NextCursor: page.NextCursor,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// GetCommentTree handles the HTTP GET request for retrieving the comments of a slug as a tree.
+// The optional parent_id query parameter roots the tree at a comment's replies, which is how
+// clients fetch further pages of replies using the NextCursor of a thread.
+func (h *Handler) GetCommentTree(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	slug := query.Get("slug")
+
+	// Check if the slug is provided
+	if slug == "" {
+		writeError(w, r, comment.InvalidFields(
+			"slug query parameter is required",
+			comment.FieldError{Field: "slug", Reason: "is required"},
+		))
+		return
+	}
+
+	// Parse the optional depth and page size, leaving them at zero so the service applies its defaults
+	depth, err := positiveIntParam(query, "depth")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	limit, err := positiveIntParam(query, "limit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Call the GetCommentTree method of the CommentService to retrieve the tree
+	tree, err := h.Service.GetCommentTree(
+		r.Context(),
+		slug,
+		query.Get("parent_id"),
+		query.Get("cursor"),
+		depth,
+		limit,
+	)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the tree as JSON and send it in the response
+	if err := json.NewEncoder(w).Encode(CommentTreeResponse{
+		Comments:   tree.Comments,
+		NextCursor: tree.NextCursor,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// SearchComments handles the HTTP GET request for searching the bodies of comments.
+// The search text is given by the required q query parameter. The optional slug, author, from and to
+// parameters narrow the search down, from and to being RFC 3339 times, and cursor and limit page through the results.
+func (h *Handler) SearchComments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Parse the optional creation time range
+	from, err := timeParam(query, "from")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, err := timeParam(query, "to")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Parse the optional page size, leaving it at zero so the service applies its default
+	limit, err := positiveIntParam(query, "limit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Call the SearchComments method of the CommentService to retrieve a page of results
+	page, err := h.Service.SearchComments(r.Context(), comment.SearchQuery{
+		Text:   query.Get("q"),
+		Slug:   query.Get("slug"),
+		Author: query.Get("author"),
+		From:   from,
+		To:     to,
+	}, query.Get("cursor"), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Encode the page of results as JSON and send it in the response
+	if err := json.NewEncoder(w).Encode(SearchCommentsResponse{
+		Results:    page.Results,
+		NextCursor: page.NextCursor,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// timeParam parses an optional RFC 3339 time query parameter.
+// An empty value returns the zero time so that callers can leave the bound open.
+func timeParam(query url.Values, name string) (time.Time, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, comment.InvalidFields(
+			fmt.Sprintf("%s query parameter must be an RFC 3339 time", name),
+			comment.FieldError{Field: name, Reason: "must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z"},
+		)
+	}
+	return parsed, nil
+}
+
+// positiveIntParam parses an optional positive integer query parameter.
+// An empty value returns zero so that callers can fall back to a default.
+func positiveIntParam(query url.Values, name string) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed < 1 {
+		return 0, comment.InvalidFields(
+			fmt.Sprintf("%s query parameter must be a positive integer", name),
+			comment.FieldError{Field: name, Reason: "must be a positive integer"},
+		)
+	}
+	return parsed, nil
+}
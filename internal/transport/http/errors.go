@@ -0,0 +1,154 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+	"github.com/go-playground/validator/v10"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details responses
+const ProblemContentType = "application/problem+json"
+
+// Problem represents an RFC 7807 problem details response body
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// InvalidParam describes a single request field that failed validation
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// validate is shared by every handler; it reports fields by their JSON names
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// validateRequest validates a decoded request body, turning validator failures
+// into an invalid input error that lists every offending field
+func validateRequest(req interface{}) error {
+	err := validate.Struct(req)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+	fields := make([]comment.FieldError, 0, len(validationErrs))
+	for _, fieldErr := range validationErrs {
+		fields = append(fields, comment.FieldError{
+			Field:  fieldErr.Field(),
+			Reason: validationReason(fieldErr),
+		})
+	}
+	return comment.InvalidFields("request body failed validation", fields...)
+}
+
+// validationReason turns a validator tag into a sentence a client can act on
+func validationReason(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "uuid":
+		return "must be a UUID"
+	case "min":
+		return "must have at least " + fieldErr.Param() + " item(s)"
+	case "oneof":
+		return "must be one of: " + fieldErr.Param()
+	default:
+		return fmt.Sprintf("failed the %q check", fieldErr.Tag())
+	}
+}
+
+// decodeJSONBody decodes a JSON request body, reporting malformed bodies as invalid input
+func decodeJSONBody(r *http.Request, dst interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		return &comment.Error{
+			Kind:    comment.KindInvalid,
+			Message: "request body is not valid JSON",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// writeError maps an error returned by the service to a problem response.
+// Domain errors keep their message; anything else is logged and reported as a 500
+// without leaking its details to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var domainErr *comment.Error
+	if !errors.As(err, &domainErr) || domainErr.Kind == comment.KindInternal {
+		logging.FromContext(r.Context()).WithError(err).Error("request failed")
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	params := make([]InvalidParam, 0, len(domainErr.Fields))
+	for _, field := range domainErr.Fields {
+		params = append(params, InvalidParam{Name: field.Field, Reason: field.Reason})
+	}
+	writeProblem(w, r, statusForKind(domainErr.Kind), domainErr.Message, params...)
+}
+
+// statusForKind picks the HTTP status code for each kind of domain error
+func statusForKind(kind comment.ErrorKind) int {
+	switch kind {
+	case comment.KindNotFound:
+		return http.StatusNotFound
+	case comment.KindInvalid:
+		return http.StatusBadRequest
+	case comment.KindConflict:
+		return http.StatusConflict
+	case comment.KindUnauthorized:
+		return http.StatusUnauthorized
+	case comment.KindForbidden:
+		return http.StatusForbidden
+	case comment.KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case comment.KindPreconditionRequired:
+		return http.StatusPreconditionRequired
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeProblem writes an RFC 7807 problem details response with the given status
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, params ...InvalidParam) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      r.URL.Path,
+		InvalidParams: params,
+	}); err != nil {
+		logging.FromContext(r.Context()).WithError(err).Error("failed to write problem response")
+	}
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantDetail string
+		wantParams []InvalidParam
+	}{
+		{
+			name:       "not found",
+			err:        comment.ErrCommentNotFound,
+			wantStatus: http.StatusNotFound,
+			wantDetail: comment.ErrCommentNotFound.Error(),
+		},
+		{
+			name: "invalid fields are listed",
+			err: comment.InvalidFields("request body failed validation",
+				comment.FieldError{Field: "slug", Reason: "is required"},
+				comment.FieldError{Field: "body", Reason: "is required"},
+			),
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "request body failed validation",
+			wantParams: []InvalidParam{{Name: "slug", Reason: "is required"}, {Name: "body", Reason: "is required"}},
+		},
+		{
+			name:       "conflict",
+			err:        comment.NewError(comment.KindConflict, "already exists"),
+			wantStatus: http.StatusConflict,
+			wantDetail: "already exists",
+		},
+		{
+			name:       "unauthorized",
+			err:        comment.NewError(comment.KindUnauthorized, "who are you"),
+			wantStatus: http.StatusUnauthorized,
+			wantDetail: "who are you",
+		},
+		{
+			name:       "forbidden",
+			err:        comment.NewError(comment.KindForbidden, "not yours"),
+			wantStatus: http.StatusForbidden,
+			wantDetail: "not yours",
+		},
+		{
+			name:       "precondition failed",
+			err:        comment.ErrVersionMismatch,
+			wantStatus: http.StatusPreconditionFailed,
+			wantDetail: comment.ErrVersionMismatch.Error(),
+		},
+		{
+			name:       "precondition required",
+			err:        comment.ErrPreconditionRequired,
+			wantStatus: http.StatusPreconditionRequired,
+			wantDetail: comment.ErrPreconditionRequired.Error(),
+		},
+		{
+			name:       "wrapped domain errors keep their kind",
+			err:        &comment.Error{Kind: comment.KindNotFound, Message: "gone", Err: errors.New("no rows")},
+			wantStatus: http.StatusNotFound,
+			wantDetail: "gone",
+		},
+		{
+			name:       "internal domain errors hide their message",
+			err:        comment.NewError(comment.KindInternal, "connection refused by 10.0.0.5"),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "unknown errors hide their message",
+			err:        errors.New("pq: relation \"comments\" does not exist"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, httptest.NewRequest(http.MethodGet, "/api/v1/comment/1", nil), tt.err)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			problem := decodeProblem(t, rec)
+			assert.Equal(t, "about:blank", problem.Type)
+			assert.Equal(t, http.StatusText(tt.wantStatus), problem.Title)
+			assert.Equal(t, tt.wantStatus, problem.Status)
+			assert.Equal(t, tt.wantDetail, problem.Detail)
+			assert.Equal(t, "/api/v1/comment/1", problem.Instance)
+			assert.Equal(t, tt.wantParams, problem.InvalidParams)
+		})
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    interface{}
+		fields []comment.FieldError
+	}{
+		{
+			name: "valid",
+			req:  PostCommentRequest{Slug: "slug", Body: "body"},
+		},
+		{
+			name: "fields are named as they are in JSON",
+			req:  PostCommentRequest{ParentID: "not-a-uuid"},
+			fields: []comment.FieldError{
+				{Field: "slug", Reason: "is required"},
+				{Field: "body", Reason: "is required"},
+				{Field: "parent_id", Reason: "must be a UUID"},
+			},
+		},
+		{
+			name:   "items of a list are named by their index",
+			req:    IssueTokenRequest{Subject: "alice", Scopes: []string{"comments:write", "root"}},
+			fields: []comment.FieldError{{Field: "scopes[1]", Reason: "must be one of: comments:write comments:moderate apikeys:admin"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRequest(tt.req)
+			if tt.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var domainErr *comment.Error
+			if assert.ErrorAs(t, err, &domainErr) {
+				assert.Equal(t, comment.KindInvalid, domainErr.Kind)
+				assert.Equal(t, tt.fields, domainErr.Fields)
+			}
+		})
+	}
+}
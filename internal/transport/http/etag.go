@@ -0,0 +1,68 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+)
+
+// etag returns the entity tag of a comment, which changes whenever the comment is updated
+func etag(cmt comment.Comment) string {
+	return `"` + strconv.Itoa(cmt.Version) + `"`
+}
+
+// setETag tells the client which version of a comment the response holds
+func setETag(w http.ResponseWriter, cmt comment.Comment) {
+	w.Header().Set("ETag", etag(cmt))
+}
+
+// entityTags splits the comma separated entity tags of every instance of a conditional request header
+func entityTags(r *http.Request, header string) []string {
+	var tags []string
+	for _, value := range r.Header.Values(header) {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// parseIfMatch reads the If-Match header into the versions of a comment the client is willing to change.
+// If-Match uses the strong comparison, so weak tags, like tags we never handed out, match no version.
+func parseIfMatch(r *http.Request) comment.IfMatch {
+	tags := entityTags(r, "If-Match")
+	if len(tags) == 0 {
+		return comment.IfMatch{}
+	}
+
+	ifMatch := comment.IfMatch{Set: true}
+	for _, tag := range tags {
+		if tag == "*" {
+			ifMatch.Any = true
+			continue
+		}
+		if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		if version, err := strconv.Atoi(tag[1 : len(tag)-1]); err == nil {
+			ifMatch.Versions = append(ifMatch.Versions, version)
+		}
+	}
+	return ifMatch
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches a comment, meaning the client
+// already has its current version. If-None-Match uses the weak comparison, so W/ prefixes are ignored.
+func ifNoneMatch(r *http.Request, cmt comment.Comment) bool {
+	current := etag(cmt)
+	for _, tag := range entityTags(r, "If-None-Match") {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    comment.IfMatch
+	}{
+		{name: "no header"},
+		{name: "a single tag", headers: []string{`"3"`}, want: comment.IfMatch{Set: true, Versions: []int{3}}},
+		{name: "any version", headers: []string{`*`}, want: comment.IfMatch{Set: true, Any: true}},
+		{name: "a list of tags", headers: []string{`"1", "2" ,"3"`}, want: comment.IfMatch{Set: true, Versions: []int{1, 2, 3}}},
+		{name: "repeated headers", headers: []string{`"1"`, `"4"`}, want: comment.IfMatch{Set: true, Versions: []int{1, 4}}},
+		// If-Match uses the strong comparison, so a weak tag never matches
+		{name: "weak tags match nothing", headers: []string{`W/"3"`}, want: comment.IfMatch{Set: true}},
+		{name: "tags we never handed out match nothing", headers: []string{`"abc", 3, "`}, want: comment.IfMatch{Set: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			for _, header := range tt.headers {
+				r.Header.Add("If-Match", header)
+			}
+			assert.Equal(t, tt.want, parseIfMatch(r))
+		})
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	cmt := comment.Comment{Version: 3}
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: `"3"`, want: true},
+		{header: `W/"3"`, want: true},
+		{header: `"1", W/"3"`, want: true},
+		{header: `*`, want: true},
+		{header: `"2"`, want: false},
+		{header: `3`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("If-None-Match", tt.header)
+			}
+			assert.Equal(t, tt.want, ifNoneMatch(r, cmt))
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		requireIfMatch bool
+		method         string
+		body           string
+		headers        []string
+		wantStatus     int
+	}{
+		{name: "get the current version", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "get a version the client has", method: http.MethodGet, headers: []string{"If-None-Match", `W/"2"`}, wantStatus: http.StatusNotModified},
+		{name: "get a version the client doesn't have", method: http.MethodGet, headers: []string{"If-None-Match", `"1"`}, wantStatus: http.StatusOK},
+		{name: "put the current version", method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, headers: []string{"If-Match", `"2"`}, wantStatus: http.StatusOK},
+		{name: "put any version", method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, headers: []string{"If-Match", `*`}, wantStatus: http.StatusOK},
+		{name: "put one of a list of versions", method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, headers: []string{"If-Match", `"1", "2"`}, wantStatus: http.StatusOK},
+		{name: "put a stale version", method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, headers: []string{"If-Match", `"1"`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "put a weak tag", method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, headers: []string{"If-Match", `W/"2"`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "patch a stale version", method: http.MethodPatch, body: `{"body": "new"}`, headers: []string{"If-Match", `"1"`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "delete a stale version", method: http.MethodDelete, headers: []string{"If-Match", `"1"`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "put without a version", method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, wantStatus: http.StatusOK},
+		{name: "put without a version when one is required", requireIfMatch: true, method: http.MethodPut, body: `{"slug": "slug", "body": "new"}`, wantStatus: http.StatusPreconditionRequired},
+		{name: "delete without a version when one is required", requireIfMatch: true, method: http.MethodDelete, wantStatus: http.StatusPreconditionRequired},
+		{name: "delete the current version when one is required", requireIfMatch: true, method: http.MethodDelete, headers: []string{"If-Match", `"2"`}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store := newTestHandler(t, config.Comments{RequireIfMatch: tt.requireIfMatch})
+			// Leave the comment at version 2
+			cmt, err := store.PostComment(context.Background(), comment.Comment{Slug: "slug", Body: "body", OwnerID: "alice"})
+			require.NoError(t, err)
+			cmt, err = store.UpdateComment(context.Background(), cmt.ID, 0, comment.Update{Body: ptr("edited")})
+			require.NoError(t, err)
+			require.Equal(t, 2, cmt.Version)
+
+			headers := append([]string{"Authorization", "Bearer " + writerToken}, tt.headers...)
+			rec := serve(h, tt.method, "/api/v1/comment/"+cmt.ID, tt.body, headers...)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			switch {
+			case tt.wantStatus == http.StatusNotModified:
+				assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+				assert.Empty(t, rec.Body.String())
+			case tt.wantStatus >= http.StatusBadRequest:
+				decodeProblem(t, rec)
+			case tt.method == http.MethodGet:
+				assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+			case tt.method != http.MethodDelete:
+				assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+			}
+		})
+	}
+}
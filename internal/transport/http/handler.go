@@ -3,13 +3,13 @@ package http
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
-	"os/signal"
 	"time"
 
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 )
 
 // Handler is a struct that handles HTTP requests
@@ -17,63 +17,165 @@ type Handler struct {
 	Router  *mux.Router
 	Service CommentService
 	Server  *http.Server
+	Config  config.Server
+	Auth    TokenVerifier
+	APIKeys APIKeyService
+	// Tokens is nil unless the development token endpoint is enabled
+	Tokens TokenIssuer
+	// Metrics records every request and is served on /metrics; it may be nil
+	Metrics RequestMetrics
+	// Health backs /healthz and /readyz
+	Health HealthReporter
 }
 
-// NewHandler creates a new instance of the Handler struct with the provided CommentService
-func NewHandler(service CommentService) *Handler {
+// RequestMetrics - records the requests the handler serves and exposes what was recorded
+type RequestMetrics interface {
+	// RequestStarted records a request starting and returns a function recording it finishing
+	RequestStarted(route, method string) func(code int)
+	// Handler serves the recorded metrics
+	Handler() http.Handler
+}
+
+// NewHandler creates a new instance of the Handler struct with the provided CommentService,
+// server settings, the verifier used to authenticate bearer tokens and the API key service.
+// tokens is only given when the development token endpoint should be served, and may be nil.
+// metrics, when not nil, records every request and is served on /metrics.
+// health reports on the server's dependencies and is told when the server starts shutting down.
+func NewHandler(
+	service CommentService,
+	cfg config.Server,
+	verifier TokenVerifier,
+	apiKeys APIKeyService,
+	tokens TokenIssuer,
+	metrics RequestMetrics,
+	health HealthReporter,
+) *Handler {
 	h := &Handler{
 		Service: service,
+		Config:  cfg,
+		Auth:    verifier,
+		APIKeys: apiKeys,
+		Tokens:  tokens,
+		Metrics: metrics,
+		Health:  health,
 	}
 
 	// Create a new mux.Router instance
 	h.Router = mux.NewRouter()
 	// Map the routes to their respective handlers
 	h.mapRoutes()
-	h.Router.Use(JSONMiddleware)
-	h.Router.Use(LoggingMiddleware)
-	h.Router.Use(TimeoutMiddleware)
+	middlewares := []mux.MiddlewareFunc{TracingMiddleware}
+	// The request ID comes after tracing so that the request's logger can carry the trace ID
+	middlewares = append(middlewares, RequestIDMiddleware)
+	// Metrics come next so the time spent in the other middlewares is measured too
+	if h.Metrics != nil {
+		middlewares = append(middlewares, MetricsMiddleware(h.Metrics))
+	}
+	middlewares = append(middlewares, JSONMiddleware, LoggingMiddleware, TimeoutMiddleware(cfg.RequestTimeout))
+	h.Router.Use(middlewares...)
+	// Requests that match no route never reach the middlewares above, so the handlers
+	// answering them are wrapped in the same chain to be traced, logged and counted too
+	h.Router.NotFoundHandler = chain(http.HandlerFunc(notFound), middlewares)
+	h.Router.MethodNotAllowedHandler = chain(http.HandlerFunc(methodNotAllowed), middlewares)
 
 	// Create a new http.Server instance and assign it to the Handler's Server field
 	h.Server = &http.Server{
-		Addr:    "0.0.0.0:8080",
+		Addr:    cfg.Addr,
 		Handler: h.Router,
 	}
 
 	return h
 }
 
-// mapRoutes defines the routes and their corresponding handlers
+// chain wraps a handler in middlewares, the first of which sees the request first
+func chain(handler http.Handler, middlewares []mux.MiddlewareFunc) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// notFound answers requests for paths no route serves with a 404 problem
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "no route matches "+r.URL.Path)
+}
+
+// methodNotAllowed answers requests for a route with a method it doesn't serve with a 405 problem
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
+
+// mapRoutes defines the routes and their corresponding handlers.
+// Routes wrapped in Authorize need an API key or bearer token granting the scopes listed after the handler.
 func (h *Handler) mapRoutes() {
 	h.Router.HandleFunc("/alive", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "I am alive")
 	})
+	h.Router.HandleFunc("/healthz", h.Liveness).Methods("GET")
+	h.Router.HandleFunc("/readyz", h.Readiness).Methods("GET")
+	if h.Metrics != nil {
+		h.Router.Handle("/metrics", h.Metrics.Handler()).Methods("GET")
+	}
 
-	h.Router.HandleFunc("/api/v1/comment", JWTAuth(h.PostComment)).Methods("POST")
+	h.Router.HandleFunc("/api/v1/comment", h.ListComments).Methods("GET")
+	h.Router.HandleFunc("/api/v1/comment", h.Authorize(h.PostComment, auth.ScopeCommentsWrite)).Methods("POST")
+	// The tree and search routes must be registered before "/api/v1/comment/{id}" so they aren't treated as IDs
+	h.Router.HandleFunc("/api/v1/comment/tree", h.GetCommentTree).Methods("GET")
+	h.Router.HandleFunc("/api/v1/comment/search", h.Authorize(h.SearchComments, auth.ScopeCommentsModerate)).Methods("GET")
 	h.Router.HandleFunc("/api/v1/comment/{id}", h.GetComment).Methods("GET")
-	h.Router.HandleFunc("/api/v1/comment/{id}", JWTAuth(h.UpdateComment)).Methods("PUT")
-	h.Router.HandleFunc("/api/v1/comment/{id}", JWTAuth(h.DeleteComment)).Methods("DELETE")
+	h.Router.HandleFunc("/api/v1/comment/{id}", h.Authorize(h.UpdateComment, auth.ScopeCommentsWrite)).Methods("PUT")
+	h.Router.HandleFunc("/api/v1/comment/{id}", h.Authorize(h.PatchComment, auth.ScopeCommentsWrite)).Methods("PATCH")
+	h.Router.HandleFunc("/api/v1/comment/{id}", h.Authorize(h.DeleteComment, auth.ScopeCommentsWrite)).Methods("DELETE")
+	h.Router.HandleFunc("/api/v1/comment/{id}/restore", h.Authorize(h.RestoreComment, auth.ScopeCommentsModerate)).Methods("POST")
+	// Revisions keep every earlier version of a comment, including content since edited away, so only moderators see them
+	h.Router.HandleFunc("/api/v1/comment/{id}/revisions", h.Authorize(h.ListRevisions, auth.ScopeCommentsModerate)).Methods("GET")
+	h.Router.HandleFunc("/api/v1/comment/{id}/revisions/diff", h.Authorize(h.DiffRevisions, auth.ScopeCommentsModerate)).Methods("GET")
+
+	h.Router.HandleFunc("/api/v1/apikeys", h.Authorize(h.CreateAPIKey, auth.ScopeAPIKeysAdmin)).Methods("POST")
+	h.Router.HandleFunc("/api/v1/apikeys", h.Authorize(h.ListAPIKeys, auth.ScopeAPIKeysAdmin)).Methods("GET")
+	h.Router.HandleFunc("/api/v1/apikeys/{id}", h.Authorize(h.RevokeAPIKey, auth.ScopeAPIKeysAdmin)).Methods("DELETE")
+
+	// Anyone can get a token from the development token endpoint, so it's only served when enabled
+	if h.Tokens != nil {
+		h.Router.HandleFunc("/api/v1/auth/token", h.IssueToken).Methods("POST")
+	}
 }
 
-// Serve starts the HTTP server and handles graceful shutdown
-func (h *Handler) Serve() error {
+// Serve starts the HTTP server and handles requests until ctx is cancelled. It returns straight
+// away if the server can't start (e.g. the port is in use). On shutdown it stops reporting ready,
+// then gives in-flight requests up to ShutdownTimeout to finish before closing their connections.
+func (h *Handler) Serve(ctx context.Context) error {
 	// Start the HTTP server in a goroutine
+	errc := make(chan error, 1)
 	go func() {
-		if err := h.Server.ListenAndServe(); err != nil {
-			log.Println(err.Error())
-		}
+		errc <- h.Server.ListenAndServe()
 	}()
 
-	// Create a channel to receive OS interrupt signals
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	// Wait for the interrupt signal to be received on the channel 'c'
-	<-c
+	// Wait until the server fails or we're told to stop
+	select {
+	case err := <-errc:
+		return fmt.Errorf("could not serve on %s: %w", h.Server.Addr, err)
+	case <-ctx.Done():
+	}
+	log.Info("shutting down")
+
+	// Stop advertising readiness so that no new traffic is routed here while draining
+	h.Health.Drain()
+	if h.Config.DrainDelay > 0 {
+		log.WithField("delay", h.Config.DrainDelay.String()).Info("waiting for load balancers to notice the server is shutting down")
+		time.Sleep(h.Config.DrainDelay)
+	}
 
 	// Create a context with a timeout to gracefully shutdown the server
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), h.Config.ShutdownTimeout)
 	defer cancel()
-	h.Server.Shutdown(ctx)
+	if err := h.Server.Shutdown(shutdownCtx); err != nil {
+		h.Server.Close()
+		return fmt.Errorf("could not drain in-flight requests within %s: %w", h.Config.ShutdownTimeout, err)
+	}
+	// ListenAndServe returns http.ErrServerClosed once Shutdown has been called
+	<-errc
 
-	log.Println("shut down gracefully")
+	log.Info("shut down gracefully")
 	return nil
 }
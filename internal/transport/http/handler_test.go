@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Tokens the fakeVerifier accepts
+const (
+	writerToken    = "writer-token"
+	moderatorToken = "moderator-token"
+)
+
+// fakeVerifier accepts a fixed set of tokens. Any other token fails with an error whose
+// details must never reach the client.
+type fakeVerifier map[string]auth.Claims
+
+func (v fakeVerifier) Verify(ctx context.Context, accessToken string) (auth.Claims, error) {
+	claims, ok := v[accessToken]
+	if !ok {
+		return auth.Claims{}, fmt.Errorf("%w: signature made with key %q does not verify", auth.ErrInvalidToken, "internal-key-1")
+	}
+	return claims, nil
+}
+
+// Keys the fakeAPIKeys service knows about
+const (
+	moderatorAPIKey = "moderator-api-key"
+	revokedAPIKey   = "revoked-api-key"
+)
+
+// fakeAPIKeys authenticates a fixed set of API keys
+type fakeAPIKeys struct{}
+
+func (fakeAPIKeys) CreateKey(ctx context.Context, key auth.APIKey) (auth.APIKey, string, error) {
+	return auth.APIKey{}, "", errors.New("not implemented")
+}
+
+func (fakeAPIKeys) ListKeys(ctx context.Context) ([]auth.APIKey, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fakeAPIKeys) RevokeKey(ctx context.Context, id string) error {
+	return errors.New("not implemented")
+}
+
+func (fakeAPIKeys) Authenticate(ctx context.Context, secret string) (auth.Claims, error) {
+	switch secret {
+	case moderatorAPIKey:
+		return auth.Claims{Subject: "apikey:mod", Scopes: []string{auth.ScopeCommentsModerate}}, nil
+	case revokedAPIKey:
+		return auth.Claims{}, fmt.Errorf("%w: api key has been revoked", auth.ErrInvalidAPIKey)
+	default:
+		return auth.Claims{}, auth.ErrInvalidAPIKey
+	}
+}
+
+// newTestHandler returns a handler serving a comment service backed by an in-memory store
+func newTestHandler(t *testing.T, cfg config.Comments) (*Handler, *memory.Store) {
+	t.Helper()
+	store := memory.NewStore()
+	verifier := fakeVerifier{
+		writerToken:    {Subject: "alice", Scopes: []string{auth.ScopeCommentsWrite}},
+		moderatorToken: {Subject: "mod", Scopes: []string{auth.ScopeCommentsWrite, auth.ScopeCommentsModerate}},
+	}
+	h := NewHandler(comment.NewService(store, cfg), config.Server{RequestTimeout: time.Minute}, verifier, fakeAPIKeys{}, nil, nil, nil)
+	return h, store
+}
+
+// serve sends a request through the handler's router. Headers are given as name, value pairs.
+func serve(h *Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeProblem reads a problem details response body
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	require.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+	var problem Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	return problem
+}
+
+// recordedRequest is a request seen by fakeMetrics
+type recordedRequest struct {
+	route, method string
+	code          int
+}
+
+// fakeMetrics remembers every request it's told about
+type fakeMetrics struct {
+	requests []recordedRequest
+}
+
+func (m *fakeMetrics) RequestStarted(route, method string) func(code int) {
+	return func(code int) {
+		m.requests = append(m.requests, recordedRequest{route: route, method: method, code: code})
+	}
+}
+
+func (m *fakeMetrics) Handler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+func TestUnmatchedRequests(t *testing.T) {
+	metrics := &fakeMetrics{}
+	h := NewHandler(nil, config.Server{RequestTimeout: time.Minute}, fakeVerifier{}, nil, nil, metrics, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "no route", method: http.MethodGet, target: "/api/v1/nothing-here", wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodDelete, target: "/healthz", wantStatus: http.StatusMethodNotAllowed},
+		{name: "made up method on no route", method: "FOOBAR", target: "/anything", wantStatus: http.StatusNotFound},
+		{name: "made up method on a route", method: "FOOBAR", target: "/healthz", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics.requests = nil
+			spans := recordSpans(t)
+			rec := serve(h, tt.method, tt.target, "")
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantStatus, decodeProblem(t, rec).Status)
+			// The middlewares ran: the request was given an ID and counted
+			assert.NotEmpty(t, rec.Header().Get(RequestIDHeader))
+			require.Len(t, metrics.requests, 1)
+			assert.Equal(t, methodLabel(tt.method), metrics.requests[0].method)
+			assert.Equal(t, tt.wantStatus, metrics.requests[0].code)
+			require.Len(t, spans.Ended(), 1)
+			assert.Equal(t, methodLabel(tt.method)+" unknown", spans.Ended()[0].Name())
+		})
+	}
+}
+
+// recordSpans installs a tracer provider that keeps every finished span in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/health"
+)
+
+// HealthReporter reports on the server and its dependencies for /healthz and /readyz
+type HealthReporter interface {
+	Live(ctx context.Context) health.Report
+	Ready(ctx context.Context) health.Report
+	// Drain makes readiness fail from then on; it is called when the server starts shutting down
+	Drain()
+}
+
+// Liveness handles the HTTP GET request for /healthz, answering 503 if the server should be restarted
+func (h *Handler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, h.Health.Live(r.Context()))
+}
+
+// Readiness handles the HTTP GET request for /readyz, answering 503 while a dependency is
+// failing or the server is shutting down
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, h.Health.Ready(r.Context()))
+}
+
+func writeHealthReport(w http.ResponseWriter, report health.Report) {
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	// Probes must always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		panic(err)
+	}
+}
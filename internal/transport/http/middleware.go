@@ -5,7 +5,24 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/logging"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/semconv/v1.17.0/httpconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName identifies the spans created for HTTP requests
+	tracerName = "github.com/JonathanBaggott/go-rest-api-course-v2/internal/transport/http"
+	// RequestIDHeader carries the ID of a request, both in the request and in its response
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds the request IDs accepted from clients
+	maxRequestIDLength = 128
 )
 
 // JSONMiddleware is a middleware that adds the JSON content type to the response header,
@@ -21,28 +38,162 @@ func JSONMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// LoggingMiddleware is a middleware that logs request details,
-// and then passes the request to the next handler in the chain.
+// LoggingMiddleware is a middleware that passes the request to the next handler in the chain
+// and then writes an access log line with the status code, response size and duration.
+// The line is written with the request's logger, so it carries the request ID.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Log the information about the handled request
-		log.WithFields(
-			log.Fields{
-				"method": r.Method,
-				"path":   r.URL.Path,
-			}).Info("handled request")
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
 		// Call the ServeHTTP method of the next http.Handler in the chain
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(rec, r)
+
+		// Log the information about the handled request
+		logging.FromContext(r.Context()).WithFields(log.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"route":       routeTemplate(r),
+			"status":      rec.Status(),
+			"bytes":       rec.bytes,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+			"user_agent":  r.UserAgent(),
+		}).Info("handled request")
 	})
 }
 
-// TimeoutMiddleware is a middleware that adds a timeout to the context of the request
-func TimeoutMiddleware(next http.Handler) http.Handler {
+// RequestIDMiddleware makes sure every request has an ID. The ID sent by the client in the
+// X-Request-ID header is kept if it looks sensible, otherwise a new one is generated.
+// The ID is echoed back in the response header and added to the request's logger.
+func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Create a new context with a timeout of 15 seconds using the request's context
-		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
-		// Ensure the cancel function is called when the handler finishes executing
-		defer cancel()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		logger := logging.WithTrace(r.Context(), log.WithField("request_id", id))
+		ctx := logging.NewContext(r.Context(), logger)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// validRequestID reports whether a client supplied request ID is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// TimeoutMiddleware returns a middleware that adds a timeout to the context of the request
+func TimeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Create a new context with the configured timeout using the request's context
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			// Ensure the cancel function is called when the handler finishes executing
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// statusRecorder remembers the status code and the number of bytes written through a ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status code before writing it
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 OK when nothing was written before the body
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Status returns the status code written, which is 200 OK if the handler never wrote one
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// routeTemplate returns the template of the route a request matched, e.g. "/api/v1/comment/{id}"
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unknown"
+}
+
+// TracingMiddleware starts a server span for every request, continuing the trace of the
+// caller when the request carries a W3C traceparent header. The span travels down to the
+// comment service and the store in the request's context.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := routeTemplate(r)
+
+		// Span names are grouped on, so like metric labels they only hold the standard methods
+		ctx, span := otel.Tracer(tracerName).Start(ctx, methodLabel(r.Method)+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(httpconv.ServerRequest("", r)...),
+			trace.WithAttributes(semconv.HTTPRoute(route)),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCode(rec.Status()))
+		span.SetStatus(httpconv.ServerStatus(rec.Status()))
+	})
+}
+
+// methodLabel returns a request's method for use in metric labels and span names. Clients can send any
+// method they like, so anything but the standard methods we might serve is reported as
+// "other" rather than creating new series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// MetricsMiddleware returns a middleware that records every request against the
+// template of the route it matched, so "/api/v1/comment/{id}" is a single series
+func MetricsMiddleware(metrics RequestMetrics) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			done := metrics.RequestStarted(routeTemplate(r), methodLabel(r.Method))
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			done(rec.Status())
+		})
+	}
+}
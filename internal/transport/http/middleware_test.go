@@ -0,0 +1,26 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMethodLabels(t *testing.T) {
+	h := NewHandler(nil, config.Server{RequestTimeout: time.Minute}, fakeVerifier{}, nil, nil, metrics.New(), nil)
+
+	// /alive answers any method, so a made up one gets all the way to a handler
+	assert.Equal(t, http.StatusOK, serve(h, "FOOBAR", "/alive", "").Code)
+	assert.Equal(t, http.StatusOK, serve(h, "BAZQUX", "/alive", "").Code)
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/alive", "").Code)
+
+	out := serve(h, http.MethodGet, "/metrics", "").Body.String()
+	assert.Contains(t, out, `http_requests_total{code="200",method="other",route="/alive"} 2`)
+	assert.Contains(t, out, `http_requests_total{code="200",method="GET",route="/alive"} 1`)
+	assert.NotContains(t, out, "FOOBAR")
+	assert.NotContains(t, out, "BAZQUX")
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+)
+
+// MergePatchContentType is the media type of RFC 7396 JSON merge patch request bodies
+const MergePatchContentType = "application/merge-patch+json"
+
+// isMergePatch reports whether a request body is declared as a merge patch.
+// Plain JSON and bodies without a content type are treated as merge patches too.
+func isMergePatch(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == MergePatchContentType || mediaType == "application/json"
+}
+
+// decodeMergePatch decodes a JSON merge patch of a comment into an update.
+// A merge patch sets the members it names and removes those it sets to null. The slug and body
+// are the only members a client may change, and as both are required neither can be removed.
+func decodeMergePatch(r *http.Request) (comment.Update, error) {
+	var raw json.RawMessage
+	if err := decodeJSONBody(r, &raw); err != nil {
+		return comment.Update{}, err
+	}
+	// Any other JSON value would replace the whole comment, which is what PUT is for
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return comment.Update{}, comment.NewError(comment.KindInvalid, "a merge patch must be a JSON object")
+	}
+	patch, err := objectMembers(raw)
+	if err != nil {
+		return comment.Update{}, &comment.Error{
+			Kind:    comment.KindInvalid,
+			Message: "request body is not valid JSON",
+			Err:     err,
+		}
+	}
+
+	var update comment.Update
+	var fields []comment.FieldError
+	seen := make(map[string]bool, len(patch))
+	for _, member := range patch {
+		name, value := member.name, member.value
+		// Member names match case-insensitively, just as they do when decoding the other request bodies,
+		// so a name given twice in any case would leave which value wins down to chance
+		key := strings.ToLower(name)
+		if seen[key] {
+			fields = append(fields, comment.FieldError{Field: name, Reason: "is given more than once"})
+			continue
+		}
+		seen[key] = true
+
+		var target **string
+		switch key {
+		case "slug":
+			target = &update.Slug
+		case "body":
+			target = &update.Body
+		default:
+			fields = append(fields, comment.FieldError{Field: name, Reason: "cannot be changed"})
+			continue
+		}
+
+		if string(value) == "null" {
+			fields = append(fields, comment.FieldError{Field: name, Reason: "is required and cannot be removed"})
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			fields = append(fields, comment.FieldError{Field: name, Reason: "must be a string"})
+			continue
+		}
+		if s == "" {
+			fields = append(fields, comment.FieldError{Field: name, Reason: "is required"})
+			continue
+		}
+		*target = &s
+	}
+
+	if len(fields) > 0 {
+		// Report the fields in a stable order rather than the map's
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+		return comment.Update{}, comment.InvalidFields("merge patch failed validation", fields...)
+	}
+	return update, nil
+}
+
+// member is a single name and value of a JSON object
+type member struct {
+	name  string
+	value json.RawMessage
+}
+
+// objectMembers splits a JSON object into its members in the order they appear,
+// keeping any repeated names that decoding into a map would silently drop
+func objectMembers(raw json.RawMessage) ([]member, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var members []member
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		m := member{name: tok.(string)}
+		if err := dec.Decode(&m.value); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
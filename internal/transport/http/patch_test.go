@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/comment"
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   comment.Update
+		fields []comment.FieldError
+	}{
+		{
+			name: "sets the members given",
+			body: `{"body": "new body"}`,
+			want: comment.Update{Body: ptr("new body")},
+		},
+		{
+			name: "member names match case-insensitively",
+			body: `{"Slug": "new-slug", "BODY": "new body"}`,
+			want: comment.Update{Slug: ptr("new-slug"), Body: ptr("new body")},
+		},
+		{
+			name: "an empty patch changes nothing",
+			body: `{}`,
+		},
+		{
+			name:   "required members can't be removed",
+			body:   `{"slug": null}`,
+			fields: []comment.FieldError{{Field: "slug", Reason: "is required and cannot be removed"}},
+		},
+		{
+			name:   "required members can't be emptied",
+			body:   `{"body": ""}`,
+			fields: []comment.FieldError{{Field: "body", Reason: "is required"}},
+		},
+		{
+			name:   "members must be strings",
+			body:   `{"body": 42}`,
+			fields: []comment.FieldError{{Field: "body", Reason: "must be a string"}},
+		},
+		{
+			name: "unknown and server managed members can't be changed",
+			body: `{"ID": "mine", "author": "mallory", "body": "fine"}`,
+			fields: []comment.FieldError{
+				{Field: "ID", Reason: "cannot be changed"},
+				{Field: "author", Reason: "cannot be changed"},
+			},
+		},
+		{
+			name:   "a member can't be given twice, whatever its case",
+			body:   `{"body": "a", "Body": "b"}`,
+			fields: []comment.FieldError{{Field: "Body", Reason: "is given more than once"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update, err := decodeMergePatch(httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tt.body)))
+			if tt.fields == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, update)
+				return
+			}
+			var domainErr *comment.Error
+			require.ErrorAs(t, err, &domainErr)
+			assert.Equal(t, comment.KindInvalid, domainErr.Kind)
+			assert.Equal(t, tt.fields, domainErr.Fields)
+		})
+	}
+
+	t.Run("the patch must be a JSON object", func(t *testing.T) {
+		for _, body := range []string{`["body"]`, `"body"`, `null`, `{"body": `} {
+			_, err := decodeMergePatch(httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body)))
+			assert.Equal(t, comment.KindInvalid, comment.KindOf(err), body)
+		}
+	})
+}
+
+func TestPatchCommentContentType(t *testing.T) {
+	h, store := newTestHandler(t, config.Comments{})
+	cmt, err := store.PostComment(context.Background(), comment.Comment{Slug: "slug", Body: "body", OwnerID: "alice"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		contentType string
+		wantStatus  int
+	}{
+		{contentType: "", wantStatus: http.StatusOK},
+		{contentType: "application/json", wantStatus: http.StatusOK},
+		{contentType: "application/merge-patch+json; charset=utf-8", wantStatus: http.StatusOK},
+		{contentType: "application/json-patch+json", wantStatus: http.StatusUnsupportedMediaType},
+		{contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{contentType: "not a media type", wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			headers := []string{"Authorization", "Bearer " + writerToken}
+			if tt.contentType != "" {
+				headers = append(headers, "Content-Type", tt.contentType)
+			}
+			rec := serve(h, http.MethodPatch, "/api/v1/comment/"+cmt.ID, `{"body": "patched"}`, headers...)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusUnsupportedMediaType {
+				assert.Equal(t, MergePatchContentType, rec.Header().Get("Accept-Patch"))
+				decodeProblem(t, rec)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
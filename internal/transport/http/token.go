@@ -0,0 +1,73 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/JonathanBaggott/go-rest-api-course-v2/internal/auth"
+)
+
+// defaultTokenLifetime is used when a token request doesn't say how long the token should last
+const defaultTokenLifetime = time.Hour
+
+// TokenIssuer signs tokens for the development token endpoint
+type TokenIssuer interface {
+	Sign(req auth.TokenRequest) (string, time.Time, error)
+}
+
+// IssueTokenRequest represents the structure of the request body for a development token
+type IssueTokenRequest struct {
+	Subject string   `json:"subject" validate:"required"`
+	Scopes  []string `json:"scopes" validate:"dive,oneof=comments:write comments:moderate apikeys:admin"`
+	Roles   []string `json:"roles" validate:"dive,oneof=commenter moderator admin"`
+	// ExpiresIn is the lifetime of the token in seconds, an hour when left out
+	ExpiresIn int `json:"expires_in" validate:"omitempty,min=1,max=86400"`
+}
+
+// TokenResponse follows the shape of an OAuth 2.0 access token response (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// IssueToken handles the HTTP POST request for a development token.
+// The route only exists when the development token endpoint is enabled.
+func (h *Handler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	var req IssueTokenRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateRequest(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ttl := defaultTokenLifetime
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	token, _, err := h.Tokens.Sign(auth.TokenRequest{
+		Subject: req.Subject,
+		Scopes:  req.Scopes,
+		Roles:   req.Roles,
+		TTL:     ttl,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Tokens must never be cached by intermediaries
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(ttl / time.Second),
+	}); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,2 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id uuid;
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS comments_slug_created_at_idx;
+
+ALTER TABLE comments
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at,
+    ALTER COLUMN body DROP NOT NULL,
+    ALTER COLUMN author DROP NOT NULL,
+    ALTER COLUMN slug DROP NOT NULL,
+    DROP CONSTRAINT IF EXISTS comments_pkey;
@@ -0,0 +1,16 @@
+-- Rows without an ID can never be addressed by the API, so they can't be kept under a primary key
+DELETE FROM comments WHERE id IS NULL;
+
+UPDATE comments SET slug = '' WHERE slug IS NULL;
+UPDATE comments SET author = '' WHERE author IS NULL;
+UPDATE comments SET body = '' WHERE body IS NULL;
+
+ALTER TABLE comments
+    ADD PRIMARY KEY (id),
+    ALTER COLUMN slug SET NOT NULL,
+    ALTER COLUMN author SET NOT NULL,
+    ALTER COLUMN body SET NOT NULL,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS comments_slug_created_at_idx ON comments (slug, created_at, id);
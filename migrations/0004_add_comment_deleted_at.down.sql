@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS comments_deleted_at_idx;
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id uuid NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    revision integer NOT NULL,
+    slug text NOT NULL,
+    author text NOT NULL,
+    body text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, revision)
+);
+
+-- Existing comments start their history from what they say today
+INSERT INTO comment_revisions (comment_id, revision, slug, author, body, created_at)
+SELECT id, 1, slug, author, body, updated_at
+FROM comments
+ON CONFLICT DO NOTHING;
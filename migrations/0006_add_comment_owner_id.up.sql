@@ -0,0 +1,2 @@
+-- Comments created before ownership was tracked have no owner; only moderators can change them.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '';
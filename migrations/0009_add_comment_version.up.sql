@@ -0,0 +1,2 @@
+-- Bumped on every update so clients can tell whether a comment changed since they fetched it.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,90 @@
+// Package migrations embeds the SQL migrations into the binary, so the server can migrate
+// its database wherever it runs without the migration files being copied alongside it.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed *.sql
+var postgresFS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFS embed.FS
+
+// Postgres returns the migrations for the postgres backend
+func Postgres() fs.FS {
+	return postgresFS
+}
+
+// SQLite returns the migrations for the sqlite backend
+func SQLite() fs.FS {
+	sub, err := fs.Sub(sqliteFS, "sqlite")
+	if err != nil {
+		// The directory is embedded, so this can only fail if the embed pattern is wrong
+		panic(err)
+	}
+	return sub
+}
+
+// Source returns a migrate source reading the migrations in fsys
+func Source(fsys fs.FS) (source.Driver, error) {
+	src, err := iofs.New(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("could not read the migrations: %w", err)
+	}
+	return src, nil
+}
+
+// Migration is a single migration that can be applied
+type Migration struct {
+	Version uint
+	Name    string
+}
+
+// List returns every migration in fsys, in the order they are applied
+func List(fsys fs.FS) ([]Migration, error) {
+	src, err := Source(fsys)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var list []Migration
+	version, err := src.First()
+	for err == nil {
+		r, name, readErr := src.ReadUp(version)
+		if readErr != nil {
+			return nil, readErr
+		}
+		r.Close()
+		list = append(list, Migration{Version: version, Name: name})
+		version, err = src.Next(version)
+	}
+	// Running out of migrations is reported as a missing file
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// VersionFields describes the version a database's schema is at, and whether a failed
+// migration left it dirty, for logging
+func VersionFields(m *migrate.Migrate) log.Fields {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return log.Fields{"schema_version": "none"}
+	}
+	if err != nil {
+		return log.Fields{"schema_version": "unknown", "version_error": err.Error()}
+	}
+	return log.Fields{"schema_version": version, "dirty": dirty}
+}
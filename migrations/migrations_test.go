@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEmbeddedMigrations checks that every migration is embedded with both directions
+// and that versions run without gaps
+func TestEmbeddedMigrations(t *testing.T) {
+	for name, fsys := range map[string]fs.FS{"postgres": Postgres(), "sqlite": SQLite()} {
+		t.Run(name, func(t *testing.T) {
+			list, err := List(fsys)
+			require.NoError(t, err)
+			require.NotEmpty(t, list)
+
+			src, err := Source(fsys)
+			require.NoError(t, err)
+			defer src.Close()
+
+			for i, m := range list {
+				assert.Equal(t, uint(i+1), m.Version, "migration %s", m.Name)
+				r, _, err := src.ReadDown(m.Version)
+				if assert.NoError(t, err, "down migration for %04d_%s", m.Version, m.Name) {
+					r.Close()
+				}
+			}
+		})
+	}
+}
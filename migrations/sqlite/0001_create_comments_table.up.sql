@@ -0,0 +1,26 @@
+-- SQLite has its own migration set; it starts from the schema the Postgres migrations arrive at.
+-- Timestamps are stored as microseconds since the Unix epoch so they sort and compare as integers.
+CREATE TABLE IF NOT EXISTS comments (
+    id TEXT PRIMARY KEY NOT NULL,
+    slug TEXT NOT NULL,
+    author TEXT NOT NULL,
+    body TEXT NOT NULL,
+    parent_id TEXT,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    deleted_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS comments_slug_created_at_idx ON comments (slug, created_at, id);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id, created_at, id);
+CREATE INDEX IF NOT EXISTS comments_deleted_at_idx ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id TEXT NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    slug TEXT NOT NULL,
+    author TEXT NOT NULL,
+    body TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (comment_id, revision)
+);
@@ -0,0 +1,99 @@
+//go:build e2e
+// +build e2e
+
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	client := resty.New()
+	admin := createTokenWithScope("apikeys:admin")
+
+	t.Run("only admins can manage keys", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			Get("http://localhost:8080/api/v1/apikeys")
+		assert.NoError(t, err)
+		assert.Equal(t, 403, resp.StatusCode())
+	})
+
+	t.Run("scopes must be known", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+admin).
+			SetBody(`{"name": "bad", "scopes": ["everything"]}`).
+			Post("http://localhost:8080/api/v1/apikeys")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+
+	resp, err := client.R().
+		SetHeader("Authorization", "bearer "+admin).
+		SetBody(`{"name": "integration", "scopes": ["comments:write"]}`).
+		Post("http://localhost:8080/api/v1/apikeys")
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode())
+
+	var created struct {
+		APIKey struct{ ID, Prefix string }
+		Key    string
+	}
+	require.NoError(t, json.Unmarshal(resp.Body(), &created))
+	assert.NotEmpty(t, created.Key)
+
+	t.Run("keys authenticate requests with their scopes", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("X-API-Key", created.Key).
+			SetBody(`{"slug": "/apikeys", "body": "posted with a key"}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		var cmt struct{ ID, OwnerID string }
+		assert.NoError(t, json.Unmarshal(resp.Body(), &cmt))
+		assert.Equal(t, "apikey:"+created.APIKey.ID, cmt.OwnerID)
+
+		resp, err = client.R().
+			SetHeader("X-API-Key", created.Key).
+			Post("http://localhost:8080/api/v1/comment/" + cmt.ID + "/restore")
+		assert.NoError(t, err)
+		assert.Equal(t, 403, resp.StatusCode())
+	})
+
+	t.Run("listing never reveals the key", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+admin).
+			Get("http://localhost:8080/api/v1/apikeys")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+		assert.Contains(t, resp.String(), created.APIKey.ID)
+		assert.NotContains(t, resp.String(), created.Key)
+	})
+
+	t.Run("revoked keys stop working", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+admin).
+			Delete("http://localhost:8080/api/v1/apikeys/" + created.APIKey.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		resp, err = client.R().
+			SetHeader("X-API-Key", created.Key).
+			SetBody(`{"slug": "/apikeys", "body": "too late"}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 401, resp.StatusCode())
+
+		resp, err = client.R().
+			SetHeader("Authorization", "bearer "+admin).
+			Delete("http://localhost:8080/api/v1/apikeys/" + created.APIKey.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 404, resp.StatusCode())
+	})
+}
@@ -4,21 +4,40 @@
 package tests
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 )
 
+// createToken returns a token allowed to write and moderate comments
 func createToken() string {
-	token := jwt.New(jwt.SigningMethodHS256)
-	tokenString, err := token.SignedString([]byte("missionimpossible"))
-	if err != nil {
-		fmt.Println(err)
+	return createTokenWithScope("comments:write comments:moderate")
+}
+
+func createTokenWithScope(scope string) string {
+	return createTokenFor("e2e-tests", scope)
+}
+
+// createTokenFor asks the development token endpoint for a token for the given subject
+// granting the given space separated scopes
+func createTokenFor(subject, scope string) string {
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	resp, err := resty.New().R().
+		SetBody(map[string]interface{}{"subject": subject, "scopes": strings.Fields(scope)}).
+		Post("http://localhost:8080/api/v1/auth/token")
+	if err == nil && resp.StatusCode() == 200 {
+		err = json.Unmarshal(resp.Body(), &token)
+	}
+	if err != nil || resp.StatusCode() != 200 {
+		fmt.Println("could not get a token:", err, resp.String())
 	}
-	return tokenString
+	return token.AccessToken
 }
 
 func TestPostComment(t *testing.T) {
@@ -26,7 +45,7 @@ func TestPostComment(t *testing.T) {
 		client := resty.New()
 		resp, err := client.R().
 			SetHeader("Authorization", "bearer "+createToken()).
-			SetBody(`{"slug": "/", "author": "Jono", "body": "hey world"}`).
+			SetBody(`{"slug": "/", "body": "hey world"}`).
 			Post("http://localhost:8080/api/v1/comment")
 		assert.NoError(t, err)
 		assert.Equal(t, 200, resp.StatusCode())
@@ -35,9 +54,447 @@ func TestPostComment(t *testing.T) {
 	t.Run("cannot post comment without JWT", func(t *testing.T) {
 		client := resty.New()
 		resp, err := client.R().
-			SetBody(`{"slug": "/", "author": "Jono", "body": "hey world"}`).
+			SetBody(`{"slug": "/", "body": "hey world"}`).
 			Post("http://localhost:8080/api/v1/comment")
 		assert.NoError(t, err)
 		assert.Equal(t, 401, resp.StatusCode())
 	})
+
+	t.Run("cannot post comment without the write scope", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createTokenWithScope("")).
+			SetBody(`{"slug": "/", "body": "hey world"}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 403, resp.StatusCode())
+		assert.Equal(t, "application/problem+json", resp.Header().Get("Content-Type"))
+	})
+}
+
+func TestListComments(t *testing.T) {
+	t.Run("can list comments for a slug", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(`{"slug": "/list", "body": "hey world"}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		resp, err = client.R().
+			SetQueryParams(map[string]string{"slug": "/list", "limit": "1"}).
+			Get("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+	})
+
+	t.Run("cannot list comments without a slug", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().Get("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+
+	t.Run("cannot list comments with an invalid cursor", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetQueryParams(map[string]string{"slug": "/list", "cursor": "not-a-cursor"}).
+			Get("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+}
+
+func TestCommentReplies(t *testing.T) {
+	t.Run("can reply to a comment and fetch the tree", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(`{"slug": "/replies", "body": "parent"}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		var parent struct{ ID string }
+		assert.NoError(t, json.Unmarshal(resp.Body(), &parent))
+
+		resp, err = client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(fmt.Sprintf(`{"slug": "/replies", "body": "reply", "parent_id": "%s"}`, parent.ID)).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		resp, err = client.R().
+			SetQueryParams(map[string]string{"slug": "/replies", "depth": "2"}).
+			Get("http://localhost:8080/api/v1/comment/tree")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+	})
+
+	t.Run("cannot reply to a comment on another slug", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(`{"slug": "/replies", "body": "parent"}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+
+		var parent struct{ ID string }
+		assert.NoError(t, json.Unmarshal(resp.Body(), &parent))
+
+		resp, err = client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(fmt.Sprintf(`{"slug": "/elsewhere", "body": "reply", "parent_id": "%s"}`, parent.ID)).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+}
+
+func TestSearchComments(t *testing.T) {
+	client := resty.New()
+	// A word no other test posts, so earlier runs against the same store only add to the results
+	for _, body := range []string{"a platypus walked in", "platypus meets platypus", "nothing to see"} {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(fmt.Sprintf(`{"slug": "/search", "body": "%s"}`, body)).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+	}
+
+	t.Run("moderators can search comments", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetQueryParams(map[string]string{"q": "platypus", "slug": "/search", "limit": "1"}).
+			Get("http://localhost:8080/api/v1/comment/search")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		var page struct {
+			Results []struct {
+				Body    string
+				Snippet string
+			}
+			NextCursor string
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body(), &page))
+		if assert.Len(t, page.Results, 1) {
+			assert.Contains(t, page.Results[0].Body, "platypus meets platypus")
+			assert.Contains(t, page.Results[0].Snippet, "<mark>platypus</mark>")
+		}
+		assert.NotEmpty(t, page.NextCursor)
+	})
+
+	t.Run("cannot search without the moderate scope", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createTokenWithScope("comments:write")).
+			SetQueryParam("q", "platypus").
+			Get("http://localhost:8080/api/v1/comment/search")
+		assert.NoError(t, err)
+		assert.Equal(t, 403, resp.StatusCode())
+	})
+
+	t.Run("cannot search with an invalid date", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetQueryParams(map[string]string{"q": "platypus", "from": "yesterday"}).
+			Get("http://localhost:8080/api/v1/comment/search")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+}
+
+func TestUpdateComment(t *testing.T) {
+	client := resty.New()
+	resp, err := client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetBody(`{"slug": "/update", "body": "original"}`).
+		Post("http://localhost:8080/api/v1/comment")
+	assert.NoError(t, err)
+
+	var cmt struct{ ID, Slug, Body, Author string }
+	assert.NoError(t, json.Unmarshal(resp.Body(), &cmt))
+	url := "http://localhost:8080/api/v1/comment/" + cmt.ID
+
+	t.Run("can patch just the body", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetHeader("Content-Type", "application/merge-patch+json").
+			SetBody(`{"body": "patched"}`).
+			Patch(url)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+
+		var patched struct{ ID, Slug, Body, Author string }
+		assert.NoError(t, json.Unmarshal(resp.Body(), &patched))
+		assert.Equal(t, cmt.ID, patched.ID)
+		assert.Equal(t, "/update", patched.Slug)
+		assert.Equal(t, "patched", patched.Body)
+		assert.Equal(t, cmt.Author, patched.Author)
+	})
+
+	t.Run("cannot patch fields the server manages or remove required ones", func(t *testing.T) {
+		for _, patch := range []string{`{"ID": "mine-now"}`, `{"author": "mallory"}`, `{"slug": null}`, `{"body": 42}`, `["body"]`, `{"body": "a", "Body": "b"}`} {
+			resp, err := client.R().
+				SetHeader("Authorization", "bearer "+createToken()).
+				SetHeader("Content-Type", "application/merge-patch+json").
+				SetBody(patch).
+				Patch(url)
+			assert.NoError(t, err)
+			assert.Equal(t, 400, resp.StatusCode(), patch)
+		}
+	})
+
+	t.Run("cannot patch with another kind of patch", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetHeader("Content-Type", "application/json-patch+json").
+			SetBody(`[{"op": "replace", "path": "/body", "value": "patched"}]`).
+			Patch(url)
+		assert.NoError(t, err)
+		assert.Equal(t, 415, resp.StatusCode())
+		assert.Equal(t, "application/merge-patch+json", resp.Header().Get("Accept-Patch"))
+	})
+
+	t.Run("cannot put a comment without every field", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(`{"body": "replaced"}`).
+			Put(url)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+}
+
+func TestCommentETags(t *testing.T) {
+	client := resty.New()
+	resp, err := client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetBody(`{"slug": "/etag", "body": "first"}`).
+		Post("http://localhost:8080/api/v1/comment")
+	assert.NoError(t, err)
+	assert.Equal(t, `"1"`, resp.Header().Get("ETag"))
+
+	var cmt struct{ ID string }
+	assert.NoError(t, json.Unmarshal(resp.Body(), &cmt))
+	url := "http://localhost:8080/api/v1/comment/" + cmt.ID
+
+	// A client holding the current version doesn't need to fetch it again
+	resp, err = client.R().SetHeader("If-None-Match", `"1"`).Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, 304, resp.StatusCode())
+	assert.Empty(t, resp.Body())
+
+	// The first moderator's edit goes through and moves the comment to the next version
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetHeader("If-Match", `"1"`).
+		SetBody(`{"slug": "/etag", "body": "second"}`).
+		Put(url)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, `"2"`, resp.Header().Get("ETag"))
+
+	// A second edit based on the old version is refused rather than overwriting the first
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetHeader("If-Match", `"1"`).
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"body": "overwritten"}`).
+		Patch(url)
+	assert.NoError(t, err)
+	assert.Equal(t, 412, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetHeader("If-Match", `"1"`).
+		Delete(url)
+	assert.NoError(t, err)
+	assert.Equal(t, 412, resp.StatusCode())
+
+	// The stale version is no longer current, so GET returns the comment in full
+	resp, err = client.R().SetHeader("If-None-Match", `"1"`).Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, `"2"`, resp.Header().Get("ETag"))
+	assert.Contains(t, resp.String(), "second")
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetHeader("If-Match", `"2"`).
+		Delete(url)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+}
+
+func TestDeleteAndRestoreComment(t *testing.T) {
+	client := resty.New()
+	resp, err := client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetBody(`{"slug": "/restore", "body": "hey world"}`).
+		Post("http://localhost:8080/api/v1/comment")
+	assert.NoError(t, err)
+
+	var cmt struct{ ID string }
+	assert.NoError(t, json.Unmarshal(resp.Body(), &cmt))
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		Delete("http://localhost:8080/api/v1/comment/" + cmt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	// Only moderators may restore comments
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createTokenWithScope("comments:write")).
+		Post("http://localhost:8080/api/v1/comment/" + cmt.ID + "/restore")
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		Post("http://localhost:8080/api/v1/comment/" + cmt.ID + "/restore")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	// The comment is no longer deleted, so a second restore has nothing to do
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		Post("http://localhost:8080/api/v1/comment/" + cmt.ID + "/restore")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode())
+}
+
+func TestCommentOwnership(t *testing.T) {
+	client := resty.New()
+	owner := createTokenFor("e2e-owner", "comments:write")
+	resp, err := client.R().
+		SetHeader("Authorization", "bearer "+owner).
+		SetBody(`{"slug": "/ownership", "body": "mine"}`).
+		Post("http://localhost:8080/api/v1/comment")
+	assert.NoError(t, err)
+
+	var cmt struct{ ID, Author, OwnerID string }
+	assert.NoError(t, json.Unmarshal(resp.Body(), &cmt))
+	// The author is whoever the token was issued to
+	assert.Equal(t, "e2e-owner", cmt.Author)
+	assert.Equal(t, "e2e-owner", cmt.OwnerID)
+
+	other := createTokenFor("e2e-other", "comments:write")
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+other).
+		SetBody(`{"Slug": "/ownership", "Body": "not yours"}`).
+		Put("http://localhost:8080/api/v1/comment/" + cmt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+other).
+		Delete("http://localhost:8080/api/v1/comment/" + cmt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode())
+
+	// Moderators may edit anyone's comment
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createTokenFor("e2e-moderator", "comments:write comments:moderate")).
+		SetBody(`{"Slug": "/ownership", "Body": "moderated"}`).
+		Put("http://localhost:8080/api/v1/comment/" + cmt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+owner).
+		Delete("http://localhost:8080/api/v1/comment/" + cmt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+}
+
+func TestCommentRevisions(t *testing.T) {
+	client := resty.New()
+	resp, err := client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetBody(`{"slug": "/revisions", "body": "hey world"}`).
+		Post("http://localhost:8080/api/v1/comment")
+	assert.NoError(t, err)
+
+	var cmt struct{ ID string }
+	assert.NoError(t, json.Unmarshal(resp.Body(), &cmt))
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createToken()).
+		SetBody(`{"Slug": "/revisions", "Body": "hey there world"}`).
+		Put("http://localhost:8080/api/v1/comment/" + cmt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	// Revisions are only shown to moderators
+	resp, err = client.R().Get("http://localhost:8080/api/v1/comment/" + cmt.ID + "/revisions")
+	assert.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+createTokenWithScope("comments:write")).
+		Get("http://localhost:8080/api/v1/comment/" + cmt.ID + "/revisions")
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode())
+
+	moderator := createToken()
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+moderator).
+		Get("http://localhost:8080/api/v1/comment/" + cmt.ID + "/revisions")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+moderator).
+		SetQueryParams(map[string]string{"from": "1", "to": "2"}).
+		Get("http://localhost:8080/api/v1/comment/" + cmt.ID + "/revisions/diff")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Authorization", "bearer "+moderator).
+		SetQueryParams(map[string]string{"from": "1", "to": "3"}).
+		Get("http://localhost:8080/api/v1/comment/" + cmt.ID + "/revisions/diff")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode())
+}
+
+func TestCommentErrors(t *testing.T) {
+	t.Run("missing comment is not found", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().Get("http://localhost:8080/api/v1/comment/00000000-0000-0000-0000-000000000000")
+		assert.NoError(t, err)
+		assert.Equal(t, 404, resp.StatusCode())
+		assert.Equal(t, "application/problem+json", resp.Header().Get("Content-Type"))
+	})
+
+	t.Run("malformed JSON is a bad request", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(`{"slug": `).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+
+	t.Run("validation errors list each invalid field", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetHeader("Authorization", "bearer "+createToken()).
+			SetBody(`{}`).
+			Post("http://localhost:8080/api/v1/comment")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+
+		var problem struct {
+			InvalidParams []struct{ Name string } `json:"invalid-params"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body(), &problem))
+		assert.Len(t, problem.InvalidParams, 2)
+	})
 }
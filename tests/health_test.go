@@ -4,10 +4,12 @@
 package tests
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHealthCheckEndpoint(t *testing.T) {
@@ -17,3 +19,21 @@ func TestHealthCheckEndpoint(t *testing.T) {
 
 	assert.Equal(t, 200, resp.StatusCode())
 }
+
+func TestLivenessAndReadiness(t *testing.T) {
+	client := resty.New()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := client.R().Get("http://localhost:8080" + path)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode(), path)
+
+		var report struct {
+			Status string                     `json:"status"`
+			Checks map[string]json.RawMessage `json:"checks"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body(), &report))
+		assert.Equal(t, "ok", report.Status, path)
+		assert.NotNil(t, report.Checks, path)
+	}
+}
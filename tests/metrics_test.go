@@ -0,0 +1,28 @@
+//go:build e2e
+// +build e2e
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	client := resty.New()
+	// Make sure at least one templated route has been hit
+	_, err := client.R().Get("http://localhost:8080/api/v1/comment/00000000-0000-0000-0000-000000000000")
+	assert.NoError(t, err)
+
+	resp, err := client.R().Get("http://localhost:8080/metrics")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode())
+
+	body := resp.String()
+	assert.Contains(t, body, `http_requests_total{code="404",method="GET",route="/api/v1/comment/{id}"}`)
+	assert.Contains(t, body, "http_requests_in_flight")
+	assert.Contains(t, body, "store_query_duration_seconds")
+	assert.Contains(t, body, "comments_posted_total")
+}
@@ -0,0 +1,34 @@
+//go:build e2e
+// +build e2e
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	client := resty.New()
+
+	t.Run("keeps the caller's request ID", func(t *testing.T) {
+		resp, err := client.R().SetHeader("X-Request-ID", "e2e-request-1").Get("http://localhost:8080/alive")
+		assert.NoError(t, err)
+		assert.Equal(t, "e2e-request-1", resp.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("generates one when missing", func(t *testing.T) {
+		resp, err := client.R().Get("http://localhost:8080/alive")
+		assert.NoError(t, err)
+		assert.Len(t, resp.Header().Get("X-Request-ID"), 36)
+	})
+
+	t.Run("replaces unusable IDs", func(t *testing.T) {
+		resp, err := client.R().SetHeader("X-Request-ID", "has spaces in it").Get("http://localhost:8080/alive")
+		assert.NoError(t, err)
+		assert.NotEqual(t, "has spaces in it", resp.Header().Get("X-Request-ID"))
+		assert.Len(t, resp.Header().Get("X-Request-ID"), 36)
+	})
+}
@@ -0,0 +1,30 @@
+//go:build e2e
+// +build e2e
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevTokenEndpoint(t *testing.T) {
+	t.Run("a subject is required", func(t *testing.T) {
+		resp, err := resty.New().R().
+			SetBody(`{"scopes": ["comments:write"]}`).
+			Post("http://localhost:8080/api/v1/auth/token")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode())
+	})
+
+	t.Run("issued tokens are not cached", func(t *testing.T) {
+		resp, err := resty.New().R().
+			SetBody(`{"subject": "e2e-tests", "expires_in": 60}`).
+			Post("http://localhost:8080/api/v1/auth/token")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode())
+		assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	})
+}